@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+
+	"cel.dev/cel-go/cel"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+)
+
+// Maximum cost of evaluating single CEL expression, same as per-expression limit of ValidatingAdmissionPolicy
+const celCostLimit = 1000000
+
+// Variables available in CEL expressions, named like in ValidatingAdmissionPolicy
+const (
+	celObject          = "object"          // Validated object, for DELETE it is the deleted object
+	celOldObject       = "oldObject"       // Existing object, null if not UPDATE
+	celRequest         = "request"         // Attributes of admission request
+	celNamespaceObject = "namespaceObject" // Namespace of validated object, null if unknown or object is not namespaced
+)
+
+// Environment used for compiling all CEL expressions
+var celEnv *cel.Env
+
+func init() {
+	var err error
+	celEnv, err = cel.NewEnv(
+		cel.Variable(celObject, cel.DynType),
+		cel.Variable(celOldObject, cel.DynType),
+		cel.Variable(celRequest, cel.DynType),
+		cel.Variable(celNamespaceObject, cel.DynType),
+	)
+	utilruntime.Must(err)
+}
+
+// compileCEL parses and type checks given expression, which must return a boolean
+// Fields of objects are dynamically typed, so expressions returning dyn are accepted and checked when evaluated
+// It also returns whether expression refers to namespace object, so namespaces can be watched only when needed
+func compileCEL(expression string) (cel.Program, bool, error) {
+	ast, issues := celEnv.Compile(expression)
+	if issues != nil && issues.Err() != nil {
+		return nil, false, issues.Err()
+	}
+
+	if outputType := ast.OutputType(); !outputType.IsExactType(cel.BoolType) && !outputType.IsExactType(cel.DynType) {
+		return nil, false, fmt.Errorf("expression must return bool, got %s", outputType)
+	}
+
+	usesNamespace := false
+	for _, reference := range ast.NativeRep().ReferenceMap() {
+		if reference.Name == celNamespaceObject {
+			usesNamespace = true
+		}
+	}
+
+	program, err := celEnv.Program(ast, cel.CostLimit(celCostLimit))
+
+	return program, usesNamespace, err
+}
+
+// evalCEL evaluates compiled expression on given validation request
+func evalCEL(program cel.Program, req *ValidationRequest) (bool, error) {
+	activation, err := celActivation(req)
+	if err != nil {
+		return false, err
+	}
+
+	out, _, err := program.Eval(activation)
+	if err != nil {
+		return false, err
+	}
+
+	result, ok := out.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("expression returned %v instead of bool", out.Value())
+	}
+
+	return result, nil
+}
+
+// celActivation converts validation request into variables of CEL expressions
+func celActivation(req *ValidationRequest) (map[string]interface{}, error) {
+	var namespaceObject interface{}
+	if req.NamespaceObject != nil {
+		namespace, err := runtime.DefaultUnstructuredConverter.ToUnstructured(req.NamespaceObject)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert namespace object: %s", err)
+		}
+		namespaceObject = namespace
+	}
+
+	return map[string]interface{}{
+		celObject:          req.Object,
+		celOldObject:       req.OldObject,
+		celRequest:         req.attributes(),
+		celNamespaceObject: namespaceObject,
+	}, nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCompileCELErrors(t *testing.T) {
+	expressions := []string{
+		"object.spec.hostNetwork ==",
+		"1 + 1",
+		"'foo'",
+		"size(object.metadata.name)",
+		"unknown.spec == true",
+	}
+
+	for _, expression := range expressions {
+		if _, _, err := compileCEL(expression); err == nil {
+			t.Errorf("Compiling invalid expression '%s' should fail", expression)
+		}
+	}
+}
+
+func TestCompileCELDyn(t *testing.T) {
+	expressions := []string{
+		"object.spec.hostNetwork",
+		"has(object.spec) ? object.spec.hostNetwork : true",
+		"object.metadata.labels['privileged']",
+	}
+
+	for _, expression := range expressions {
+		if _, _, err := compileCEL(expression); err != nil {
+			t.Errorf("Compiling expression '%s' of dyn type shouldn't fail: %s", expression, err)
+		}
+	}
+}
+
+func TestEvalCELDyn(t *testing.T) {
+	program, _, err := compileCEL("object.spec.hostNetwork")
+	if err != nil {
+		t.Fatalf("Compiling expression shouldn't fail: %s", err)
+	}
+
+	cases := map[string]bool{
+		`{"spec":{"hostNetwork":true}}`:  true,
+		`{"spec":{"hostNetwork":false}}`: false,
+	}
+
+	for raw, expected := range cases {
+		var object map[string]interface{}
+		if err := json.Unmarshal([]byte(raw), &object); err != nil {
+			t.Fatalf("Deserializing should not fail: %s", err)
+		}
+		result, err := evalCEL(program, &ValidationRequest{UID: "TestEvalCELDyn", Object: object})
+		if err != nil {
+			t.Errorf("Evaluating expression on object %s shouldn't fail: %s", raw, err)
+		}
+		if result != expected {
+			t.Errorf("Expected result %t for object %s, got: %t", expected, raw, result)
+		}
+	}
+
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"spec":{"hostNetwork":"yes"}}`), &object); err != nil {
+		t.Fatalf("Deserializing should not fail: %s", err)
+	}
+	if _, err := evalCEL(program, &ValidationRequest{UID: "TestEvalCELDyn", Object: object}); err == nil {
+		t.Errorf("Evaluating expression returning string should fail")
+	}
+}
+
+func TestEvalCELVariables(t *testing.T) {
+	var object, oldObject map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"metadata":{"name":"foo","labels":{"team":"a"}},"spec":{"replicas":3}}`), &object); err != nil {
+		t.Fatalf("Deserializing should not fail: %s", err)
+	}
+	if err := json.Unmarshal([]byte(`{"metadata":{"name":"foo","labels":{"team":"b"}},"spec":{"replicas":1}}`), &oldObject); err != nil {
+		t.Fatalf("Deserializing should not fail: %s", err)
+	}
+
+	req := &ValidationRequest{
+		UID:       "TestEvalCELVariables",
+		Kind:      metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+		Name:      "foo",
+		Namespace: "prod",
+		Operation: "UPDATE",
+		UserInfo: authenticationv1.UserInfo{
+			Username: "alice",
+			Groups:   []string{"developers"},
+			Extra:    map[string]authenticationv1.ExtraValue{"scopes": {"admin"}},
+		},
+		NamespaceObject: &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "prod",
+				Labels: map[string]string{"environment": "production"},
+			},
+		},
+		Object:    object,
+		OldObject: oldObject,
+	}
+
+	expressions := map[string]bool{
+		"object.spec.replicas == 3":                                           true,
+		"object.spec.replicas > oldObject.spec.replicas":                      true,
+		"object.metadata.labels.team == oldObject.metadata.labels.team":       false,
+		"has(object.spec.template)":                                           false,
+		"request.operation == 'UPDATE' && request.namespace == 'prod'":        true,
+		"request.kind.group == 'apps' && request.kind.kind == 'Deployment'":   true,
+		"request.userInfo.username == 'alice'":                                true,
+		"'developers' in request.userInfo.groups":                             true,
+		"'admin' in request.userInfo.extra.scopes":                            true,
+		"namespaceObject.metadata.labels.environment == 'production'":         true,
+		"namespaceObject.metadata.name == request.namespace":                  true,
+		"object.metadata.labels.all(key, object.metadata.labels[key] != 'b')": true,
+	}
+
+	for expression, expected := range expressions {
+		program, _, err := compileCEL(expression)
+		if err != nil {
+			t.Errorf("Compiling expression '%s' shouldn't fail: %s", expression, err)
+			continue
+		}
+		result, err := evalCEL(program, req)
+		if err != nil {
+			t.Errorf("Evaluating expression '%s' shouldn't fail: %s", expression, err)
+			continue
+		}
+		if result != expected {
+			t.Errorf("Expected expression '%s' to return %t, got: %t", expression, expected, result)
+		}
+	}
+}
+
+func TestEvalCELNullVariables(t *testing.T) {
+	req := &ValidationRequest{
+		UID:       "TestEvalCELNullVariables",
+		Kind:      metav1.GroupVersionKind{Kind: "Foo"},
+		Operation: "CREATE",
+		Object:    map[string]interface{}{},
+	}
+
+	program, _, err := compileCEL("oldObject == null && namespaceObject == null")
+	if err != nil {
+		t.Fatalf("Compiling expression shouldn't fail: %s", err)
+	}
+
+	result, err := evalCEL(program, req)
+	if err != nil {
+		t.Fatalf("Evaluating expression shouldn't fail: %s", err)
+	}
+	if !result {
+		t.Errorf("Old object and namespace object should be null if not set")
+	}
+}
+
+func TestCompileCELUsesNamespace(t *testing.T) {
+	expressions := map[string]bool{
+		"namespaceObject.metadata.labels.environment == 'production'": true,
+		"has(object.metadata.labels) && namespaceObject == null":      true,
+		"object.metadata.namespace == 'namespaceObject'":              false,
+		"request.namespace == 'prod'":                                 false,
+	}
+
+	for expression, expected := range expressions {
+		_, usesNamespace, err := compileCEL(expression)
+		if err != nil {
+			t.Errorf("Compiling expression '%s' shouldn't fail: %s", expression, err)
+			continue
+		}
+		if usesNamespace != expected {
+			t.Errorf("Expected expression '%s' to use namespace object: %t, got: %t", expression, expected, usesNamespace)
+		}
+	}
+}
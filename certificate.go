@@ -0,0 +1,56 @@
+package main
+
+import (
+	"crypto/tls"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// CertificateReloader keeps x509 key pair loaded from files and allows to reload it,
+// so rotated certificates are served without restarting the server
+type CertificateReloader struct {
+	certFile    string           // Path to the x509 certificate
+	keyFile     string           // Path to the x509 private key matching certFile
+	certificate *tls.Certificate // Currently served key pair
+	mutex       sync.RWMutex     // Protects certificate
+}
+
+// NewCertificateReloader creates new CertificateReloader instance with key pair loaded from given files
+func NewCertificateReloader(certFile, keyFile string) (*CertificateReloader, error) {
+	cr := &CertificateReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	if err := cr.Reload(); err != nil {
+		return nil, err
+	}
+
+	return cr, nil
+}
+
+// Reload loads key pair from files and replaces currently served one
+// If key pair can't be loaded, current one is kept
+func (cr *CertificateReloader) Reload() error {
+	pair, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+
+	cr.mutex.Lock()
+	cr.certificate = &pair
+	cr.mutex.Unlock()
+
+	glog.Infof("Loaded key pair from %s and %s", cr.certFile, cr.keyFile)
+
+	return nil
+}
+
+// GetCertificate returns currently served key pair, it is meant to be used in tls.Config
+func (cr *CertificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mutex.RLock()
+	defer cr.mutex.RUnlock()
+
+	return cr.certificate, nil
+}
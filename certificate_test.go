@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeKeyPair generates self-signed key pair with given common name and writes it to given files
+func writeKeyPair(t *testing.T, certFile, keyFile, commonName string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Generating key should not fail: %s", err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Creating certificate should not fail: %s", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Marshaling key should not fail: %s", err)
+	}
+
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0600); err != nil {
+		t.Fatalf("Writing certificate should not fail: %s", err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("Writing key should not fail: %s", err)
+	}
+}
+
+func TestNewCertificateReloaderMissingFiles(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := NewCertificateReloader(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")); err == nil {
+		t.Errorf("Creating reloader with missing key pair should fail")
+	}
+}
+
+func TestCertificateReloaderReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeKeyPair(t, certFile, keyFile, "foo")
+
+	cr, err := NewCertificateReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("Creating reloader should not fail: %s", err)
+	}
+
+	writeKeyPair(t, certFile, keyFile, "bar")
+	if err := cr.Reload(); err != nil {
+		t.Fatalf("Reloading key pair should not fail: %s", err)
+	}
+
+	cert, err := cr.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("Getting certificate should not fail: %s", err)
+	}
+	if cert.Leaf == nil || cert.Leaf.Subject.CommonName != "bar" {
+		t.Errorf("Reloaded certificate should be served")
+	}
+}
+
+func TestCertificateReloaderKeepOnError(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeKeyPair(t, certFile, keyFile, "foo")
+
+	cr, err := NewCertificateReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("Creating reloader should not fail: %s", err)
+	}
+
+	if err := ioutil.WriteFile(keyFile, []byte("foo"), 0600); err != nil {
+		t.Fatalf("Writing key should not fail: %s", err)
+	}
+	if err := cr.Reload(); err == nil {
+		t.Errorf("Reloading invalid key pair should fail")
+	}
+
+	cert, err := cr.GetCertificate(nil)
+	if err != nil || cert == nil || cert.Leaf.Subject.CommonName != "foo" {
+		t.Errorf("Previous certificate should be kept when reload fails")
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+)
+
+// ruleCondition stores parsed version of Condition
+// Condition either checks output of JSONPath query like match rules do, or combines other conditions
+type ruleCondition struct {
+	jsonpath *Query          // Parsed JSONPath query, nil for combined conditions
+	path     string          // JSONPath query as defined in config
+	regexp   *regexp.Regexp  // Compiled Regexp
+	required bool            // Whether query output must match regexp for condition not to hold
+	allOf    []ruleCondition // Conditions, which must all hold
+	anyOf    []ruleCondition // Conditions, at least one of which must hold
+	not      *ruleCondition  // Condition, which must not hold
+}
+
+// isComposite returns true if condition combines other conditions rather than checking query output
+func (c Condition) isComposite() bool {
+	return len(c.AllOf) > 0 || len(c.AnyOf) > 0 || c.Not != nil
+}
+
+// parseCondition parses tree of conditions, each of them must either check JSONPath query or combine other conditions
+// Name is used for naming JSONPath queries
+func parseCondition(name string, condition Condition) (*ruleCondition, error) {
+	set := 0
+	for _, isSet := range []bool{condition.Jsonpath != "", len(condition.AllOf) > 0, len(condition.AnyOf) > 0, condition.Not != nil} {
+		if isSet {
+			set++
+		}
+	}
+	if set != 1 {
+		return nil, fmt.Errorf("Condition must have exactly one of jsonpath, allOf, anyOf or not")
+	}
+
+	parsed := &ruleCondition{path: condition.Jsonpath}
+
+	if condition.isComposite() {
+		if condition.Regexp != "" || condition.Match != "" {
+			return nil, fmt.Errorf("Regexp and match can only be used in conditions with jsonpath")
+		}
+
+		for _, subcondition := range condition.AllOf {
+			child, err := parseCondition(name, subcondition)
+			if err != nil {
+				return nil, err
+			}
+			parsed.allOf = append(parsed.allOf, *child)
+		}
+
+		for _, subcondition := range condition.AnyOf {
+			child, err := parseCondition(name, subcondition)
+			if err != nil {
+				return nil, err
+			}
+			parsed.anyOf = append(parsed.anyOf, *child)
+		}
+
+		if condition.Not != nil {
+			child, err := parseCondition(name, *condition.Not)
+			if err != nil {
+				return nil, err
+			}
+			parsed.not = child
+		}
+
+		return parsed, nil
+	}
+
+	parsed.jsonpath = NewQuery(name)
+	parsed.jsonpath.AllowMissingKeys(true)
+	if err := parsed.jsonpath.Parse(condition.Jsonpath); err != nil {
+		return nil, err
+	}
+
+	switch condition.Match {
+	case "", matchForbidden:
+	case matchRequired:
+		parsed.required = true
+	default:
+		return nil, fmt.Errorf("Unsupported match mode '%s', expected '%s' or '%s'", condition.Match, matchForbidden, matchRequired)
+	}
+
+	if condition.Regexp != "" {
+		regexp, err := regexp.Compile(condition.Regexp)
+		if err != nil {
+			return nil, err
+		}
+		parsed.regexp = regexp
+	}
+
+	return parsed, nil
+}
+
+// holds evaluates condition on given object
+// Condition with JSONPath query holds if match rule with the same query, regexp and match mode would reject the object
+func (c *ruleCondition) holds(object interface{}) (bool, error) {
+	switch {
+	case c.not != nil:
+		holds, err := c.not.holds(object)
+		if err != nil {
+			return false, err
+		}
+		return !holds, nil
+	case len(c.allOf) > 0:
+		for i := range c.allOf {
+			if holds, err := c.allOf[i].holds(object); err != nil || !holds {
+				return false, err
+			}
+		}
+		return true, nil
+	case len(c.anyOf) > 0:
+		for i := range c.anyOf {
+			if holds, err := c.anyOf[i].holds(object); err != nil || holds {
+				return holds, err
+			}
+		}
+		return false, nil
+	}
+
+	buf := new(bytes.Buffer)
+	if err := c.jsonpath.Execute(buf, object); err != nil {
+		return false, fmt.Errorf("query '%s' failed: %s", c.path, err)
+	}
+
+	return check(c.regexp, c.required, buf.String()) != "", nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestConditionHolds(t *testing.T) {
+	privileged := Condition{Jsonpath: "{.spec.containers[*].securityContext.privileged}", Regexp: "true"}
+	system := Condition{Jsonpath: "{.metadata.namespace}", Regexp: "^system-"}
+	team := Condition{Jsonpath: "{.metadata.labels.team}"}
+	owner := Condition{Jsonpath: "{.metadata.annotations.owner}", Regexp: "^[a-z]+$", Match: "required"}
+
+	objects := map[string]string{
+		"privileged in system":   `{"metadata":{"namespace":"system-dns","labels":{"team":"a"}},"spec":{"containers":[{"securityContext":{"privileged":true}}]}}`,
+		"privileged in prod":     `{"metadata":{"namespace":"prod","annotations":{"owner":"alice"}},"spec":{"containers":[{"securityContext":{"privileged":true}}]}}`,
+		"unprivileged in prod":   `{"metadata":{"namespace":"prod","labels":{"team":"a"},"annotations":{"owner":"alice"}},"spec":{"containers":[{"name":"web"}]}}`,
+		"unprivileged in system": `{"metadata":{"namespace":"system-dns","annotations":{"owner":"Bob"}},"spec":{"containers":[{"securityContext":{"privileged":false}}]}}`,
+	}
+
+	cases := []struct {
+		name      string
+		condition Condition
+		holds     map[string]bool
+	}{
+		{
+			name:      "jsonpath with regexp",
+			condition: privileged,
+			holds:     map[string]bool{"privileged in system": true, "privileged in prod": true},
+		},
+		{
+			name:      "jsonpath without regexp",
+			condition: team,
+			holds:     map[string]bool{"privileged in system": true, "unprivileged in prod": true},
+		},
+		{
+			name:      "jsonpath with required match",
+			condition: owner,
+			holds:     map[string]bool{"privileged in system": true, "unprivileged in system": true},
+		},
+		{
+			name:      "allOf",
+			condition: Condition{AllOf: []Condition{privileged, team}},
+			holds:     map[string]bool{"privileged in system": true},
+		},
+		{
+			name:      "anyOf",
+			condition: Condition{AnyOf: []Condition{privileged, owner}},
+			holds:     map[string]bool{"privileged in system": true, "privileged in prod": true, "unprivileged in system": true},
+		},
+		{
+			name:      "not",
+			condition: Condition{Not: &system},
+			holds:     map[string]bool{"privileged in prod": true, "unprivileged in prod": true},
+		},
+		{
+			name:      "not of not",
+			condition: Condition{Not: &Condition{Not: &system}},
+			holds:     map[string]bool{"privileged in system": true, "unprivileged in system": true},
+		},
+		{
+			name:      "allOf with not",
+			condition: Condition{AllOf: []Condition{privileged, {Not: &system}}},
+			holds:     map[string]bool{"privileged in prod": true},
+		},
+		{
+			name:      "not of anyOf",
+			condition: Condition{Not: &Condition{AnyOf: []Condition{privileged, team}}},
+			holds:     map[string]bool{"unprivileged in system": true},
+		},
+		{
+			name:      "not of allOf",
+			condition: Condition{Not: &Condition{AllOf: []Condition{system, owner}}},
+			holds:     map[string]bool{"privileged in prod": true, "unprivileged in prod": true},
+		},
+		{
+			name:      "anyOf with nested allOf",
+			condition: Condition{AnyOf: []Condition{{AllOf: []Condition{privileged, {Not: &system}}}, {AllOf: []Condition{system, owner}}}},
+			holds:     map[string]bool{"privileged in prod": true, "privileged in system": true, "unprivileged in system": true},
+		},
+		{
+			name:      "allOf with nested anyOf",
+			condition: Condition{AllOf: []Condition{{AnyOf: []Condition{team, owner}}, {Not: &Condition{AnyOf: []Condition{privileged}}}}},
+			holds:     map[string]bool{"unprivileged in prod": true, "unprivileged in system": true},
+		},
+	}
+
+	for _, c := range cases {
+		condition, err := parseCondition(c.name, c.condition)
+		if err != nil {
+			t.Errorf("Parsing condition %s shouldn't fail: %s", c.name, err)
+			continue
+		}
+
+		for name, data := range objects {
+			var object map[string]interface{}
+			if err := json.Unmarshal([]byte(data), &object); err != nil {
+				t.Fatalf("Deserializing should not fail: %s", err)
+			}
+
+			holds, err := condition.holds(object)
+			if err != nil {
+				t.Errorf("Evaluating condition %s on object %s shouldn't fail: %s", c.name, name, err)
+				continue
+			}
+			if holds != c.holds[name] {
+				t.Errorf("Expected condition %s to hold for object %s: %t, got: %t", c.name, name, c.holds[name], holds)
+			}
+		}
+	}
+}
+
+func TestConditionHoldsError(t *testing.T) {
+	condition, err := parseCondition("TestConditionHoldsError", Condition{
+		AnyOf: []Condition{
+			{Jsonpath: "{.metadata.name}", Regexp: "^foo$"},
+			{Jsonpath: "{.spec.replicas[0]}"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Parsing condition shouldn't fail: %s", err)
+	}
+
+	if _, err := condition.holds(map[string]interface{}{"metadata": map[string]interface{}{"name": "bar"}, "spec": map[string]interface{}{"replicas": 3}}); err == nil {
+		t.Errorf("Evaluating condition should fail if query can't be executed")
+	}
+
+	// anyOf stops evaluating conditions after first one which holds
+	holds, err := condition.holds(map[string]interface{}{"metadata": map[string]interface{}{"name": "foo"}, "spec": map[string]interface{}{"replicas": 3}})
+	if err != nil || !holds {
+		t.Errorf("Condition should hold without evaluating remaining conditions, got: %t, %v", holds, err)
+	}
+}
+
+func TestParseConditionErrors(t *testing.T) {
+	conditions := map[string]Condition{
+		"empty condition":          {},
+		"jsonpath and allOf":       {Jsonpath: "{.metadata.name}", AllOf: []Condition{{Jsonpath: "{.spec}"}}},
+		"anyOf and not":            {AnyOf: []Condition{{Jsonpath: "{.spec}"}}, Not: &Condition{Jsonpath: "{.spec}"}},
+		"regexp with allOf":        {Regexp: "foo", AllOf: []Condition{{Jsonpath: "{.spec}"}}},
+		"match with not":           {Match: "required", Not: &Condition{Jsonpath: "{.spec}"}},
+		"malformed jsonpath":       {Jsonpath: "{.metadata.name"},
+		"malformed regexp":         {Jsonpath: "{.metadata.name}", Regexp: "(foo"},
+		"unsupported match":        {Jsonpath: "{.metadata.name}", Match: "foo"},
+		"invalid nested condition": {AllOf: []Condition{{Jsonpath: "{.spec}"}, {Not: &Condition{AnyOf: []Condition{{Regexp: "foo"}}}}}},
+	}
+
+	for name, condition := range conditions {
+		if _, err := parseCondition(name, condition); err == nil {
+			t.Errorf("Parsing condition with %s should fail", name)
+		}
+	}
+}
@@ -2,43 +2,100 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
 	"flag"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
+// How often namespace cache is resynchronized
+const namespaceResync = 10 * time.Minute
+
 func main() {
 	var parameters WhSvrParameters
 
 	// Get command line parameters
 	flag.IntVar(&parameters.port, "port", 8443, "Webhook server port.")
+	flag.IntVar(&parameters.metricsPort, "metricsPort", 8080, "Plain HTTP port for metrics endpoint.")
 	flag.StringVar(&parameters.certFile, "tlsCertFile", "/validating-admission-webhook/certs/cert.pem", "File containing the x509 Certificate for HTTPS.")
 	flag.StringVar(&parameters.keyFile, "tlsKeyFile", "/validating-admission-webhook/certs/key.pem", "File containing the x509 private key to --tlsCertFile.")
 	flag.StringVar(&parameters.configFile, "configFile", "/validating-admission-webhook/config.yaml", "File containing validation rules.")
+	flag.BoolVar(&parameters.strict, "strict", true, "Refuse to start or reload with invalid configuration, instead of skipping invalid rules.")
+	flag.IntVar(&parameters.minRules, "minRules", 1, "Minimum number of loaded rules required to report readiness.")
+	flag.DurationVar(&parameters.certExpiryThreshold, "certExpiryThreshold", 24*time.Hour, "Report not ready if certificate expires within given duration.")
+	flag.StringVar(&parameters.kubeconfig, "kubeconfig", "", "Kubeconfig file used for looking up namespace labels. In-cluster configuration is used if empty.")
 	flag.Parse()
 
 	// Load certificates
-	pair, err := tls.LoadX509KeyPair(parameters.certFile, parameters.keyFile)
+	certificates, err := NewCertificateReloader(parameters.certFile, parameters.keyFile)
+	if err != nil {
+		glog.Exitf("Failed to load key pair: %v", err)
+	}
+
+	// Reload certificates when they are rotated
+	reloadCertificates := func() {
+		if err := certificates.Reload(); err != nil {
+			glog.Errorf("Failed to reload key pair, keeping previous one: %v", err)
+		}
+	}
+	certificatesWatcher, err := NewFileWatcher(reloadCertificates, parameters.certFile, parameters.keyFile)
 	if err != nil {
-		glog.Errorf("Failed to load key pair: %v", err)
+		glog.Errorf("Failed to watch key pair files, key pair will only be reloaded on SIGHUP: %v", err)
+	} else {
+		go certificatesWatcher.Run()
+		defer certificatesWatcher.Close()
 	}
 
 	// Create new WebhookServer instance
-	whsvr := NewWebhookServer(parameters.port, pair)
+	whsvr := NewWebhookServer(parameters.port, certificates)
+	whsvr.minRules = parameters.minRules
+	whsvr.certExpiryThreshold = parameters.certExpiryThreshold
+
+	// Prepare watching namespaces, so rules can be scoped using namespace labels
+	// Namespaces are watched only once config with rules using namespace labels is loaded
+	if config, err := clientcmd.BuildConfigFromFlags("", parameters.kubeconfig); err != nil {
+		glog.Errorf("Failed to create Kubernetes client configuration, rules with namespace selector will reject objects: %v", err)
+	} else if client, err := kubernetes.NewForConfig(config); err != nil {
+		glog.Errorf("Failed to create Kubernetes client, rules with namespace selector will reject objects: %v", err)
+	} else {
+		whsvr.namespaces = NewNamespaceCache(client, namespaceResync)
+		defer whsvr.namespaces.Close()
+	}
 
 	// Read and parse config
-	whsvr.readConfig(parameters.configFile)
+	if err := whsvr.readConfig(parameters.configFile, parameters.strict); err != nil {
+		if parameters.strict {
+			glog.Exitf("Failed to load config file: %s", err)
+		}
+		glog.Errorf("Failed to load config file, no validation will be performed: %s", err)
+	}
+
+	// Reload config when config file changes
+	configWatcher, err := NewFileWatcher(func() {
+		whsvr.reloadConfig(parameters.configFile, parameters.strict)
+	}, parameters.configFile)
+	if err != nil {
+		glog.Errorf("Failed to watch config file, config will only be reloaded on SIGHUP: %v", err)
+	} else {
+		go configWatcher.Run()
+		defer configWatcher.Close()
+	}
 
 	// Define http server and server handler
 	mux := http.NewServeMux()
 
 	// Register path handlers
 	mux.HandleFunc("/validate", whsvr.serve)
+	mux.HandleFunc("/healthz", whsvr.healthz)
+	mux.HandleFunc("/readyz", whsvr.readyz)
 	whsvr.server.Handler = mux
 
 	// Start webhook server in new goroutine
@@ -48,15 +105,37 @@ func main() {
 		}
 	}()
 
+	// Start metrics server in new goroutine
+	metricsMux := http.NewServeMux()
+	metricsMux.Handle("/metrics", promhttp.Handler())
+	metricsServer := &http.Server{
+		Addr:    fmt.Sprintf(":%v", parameters.metricsPort),
+		Handler: metricsMux,
+	}
+	go func() {
+		if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			glog.Errorf("Failed to listen and serve metrics server: %v", err)
+		}
+	}()
+
 	glog.Info("Listening for incoming requests...")
 
-	// Listen for OS shutdown signal
+	// Listen for OS shutdown signal and reload config and certificates on SIGHUP
 	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
-	<-signalChan
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range signalChan {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		whsvr.reloadConfig(parameters.configFile, parameters.strict)
+		reloadCertificates()
+	}
 
 	glog.Infof("Got OS shutdown signal, shutting down webhook server gracefully...")
 	if err := whsvr.server.Shutdown(context.Background()); err != nil {
 		glog.Errorf("Failed to shut down webhook server gracefully: %v", err)
 	}
+	if err := metricsServer.Shutdown(context.Background()); err != nil {
+		glog.Errorf("Failed to shut down metrics server gracefully: %v", err)
+	}
 }
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Prefix of all exported metrics
+const metricsNamespace = "validating_admission_webhook"
+
+// Metrics exported on metrics endpoint
+var (
+	admissionRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "admission_requests_total",
+		Help:      "Number of processed admission requests by kind, operation and decision.",
+	}, []string{"kind", "operation", "allowed"})
+
+	ruleRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rule_rejections_total",
+		Help:      "Number of objects rejected by each rule, including objects only recorded by rules in audit mode.",
+	}, []string{"kind", "rule", "enforcement"})
+
+	ruleExemptions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rule_exemptions_total",
+		Help:      "Number of rule evaluations skipped, because user sending the request is exempted from the rule.",
+	}, []string{"kind", "rule"})
+
+	decodeErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "decode_errors_total",
+		Help:      "Number of admission requests or objects, which could not be decoded.",
+	})
+
+	requestDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "request_duration_seconds",
+		Help:      "Time spent serving admission requests.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	configReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "config_reloads_total",
+		Help:      "Number of config file reloads by result.",
+	}, []string{"result"})
+)
+
+// Register metrics in default registry, so they are exported by promhttp.Handler
+func init() {
+	prometheus.MustRegister(admissionRequests, ruleRejections, ruleExemptions, decodeErrors, requestDuration, configReloads)
+}
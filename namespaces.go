@@ -0,0 +1,69 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// NamespaceCache keeps namespaces of the cluster up to date using informer, so labels of namespaces
+// can be looked up when validating objects without querying API server for every request
+type NamespaceCache struct {
+	factory informers.SharedInformerFactory // Factory running namespace informer
+	lister  corev1listers.NamespaceLister   // Lister reading namespaces from informer cache
+	synced  cache.InformerSynced            // Reports if informer cache has been filled
+	done    chan struct{}                   // Closed when cache is stopped
+	start   sync.Once                       // Makes sure informer is started only once
+}
+
+// NewNamespaceCache creates new NamespaceCache instance using given client
+// Namespaces are fetched when Run method is called
+func NewNamespaceCache(client kubernetes.Interface, resync time.Duration) *NamespaceCache {
+	factory := informers.NewSharedInformerFactory(client, resync)
+	informer := factory.Core().V1().Namespaces()
+
+	return &NamespaceCache{
+		factory: factory,
+		lister:  informer.Lister(),
+		synced:  informer.Informer().HasSynced,
+		done:    make(chan struct{}),
+	}
+}
+
+// Run starts watching namespaces until cache is closed
+func (nc *NamespaceCache) Run() {
+	nc.factory.Start(nc.done)
+
+	if cache.WaitForCacheSync(nc.done, nc.synced) {
+		glog.Infof("Namespace cache synced")
+	}
+}
+
+// Start runs cache in new goroutine, unless it has already been started
+func (nc *NamespaceCache) Start() {
+	nc.start.Do(func() {
+		go nc.Run()
+	})
+}
+
+// Close stops watching namespaces
+func (nc *NamespaceCache) Close() {
+	close(nc.done)
+	nc.factory.Shutdown()
+}
+
+// HasSynced checks if all namespaces has been fetched
+func (nc *NamespaceCache) HasSynced() bool {
+	return nc.synced()
+}
+
+// Get returns namespace with given name from cache
+func (nc *NamespaceCache) Get(name string) (*corev1.Namespace, error) {
+	return nc.lister.Get(name)
+}
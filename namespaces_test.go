@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNamespaceCacheGet(t *testing.T) {
+	client := fake.NewClientset(&corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "prod-foo",
+			Labels: map[string]string{"environment": "production"},
+		},
+	})
+
+	nc := NewNamespaceCache(client, 0)
+	defer nc.Close()
+
+	if nc.HasSynced() {
+		t.Errorf("Namespace cache shouldn't be synced before running")
+	}
+
+	go nc.Run()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !nc.HasSynced() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Namespace cache should be synced")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	namespace, err := nc.Get("prod-foo")
+	if err != nil {
+		t.Fatalf("Getting existing namespace shouldn't fail: %s", err)
+	}
+	if namespace.Labels["environment"] != "production" {
+		t.Errorf("Namespace labels should be cached, got: %v", namespace.Labels)
+	}
+
+	if _, err := nc.Get("nonexistent"); err == nil {
+		t.Errorf("Getting nonexistent namespace should fail")
+	}
+}
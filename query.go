@@ -0,0 +1,1009 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	jsonpath "k8s.io/client-go/util/jsonpath"
+)
+
+// Beginning of filter expression in JSONPath query
+const filterStart = "[?("
+
+// Query executes JSONPath templates in the same way as k8s.io/client-go/util/jsonpath, but additionally
+// supports filter expressions with comparison, regular expression and logical operators, e.g.
+// {.spec.containers[?(@.image !~ /^registry\.corp\// && @.name != 'sidecar')].name}
+type Query struct {
+	name             string             // Name used in error messages
+	allowMissingKeys bool               // Whether missing keys should result in empty output rather than error
+	parts            []queryPart        // Parsed template, only set if template contains filters
+	jsonpath         *jsonpath.JSONPath // Parsed template if it doesn't contain filters, also used for printing results
+}
+
+// queryPart is either literal text, action without filters or action with filters
+// Range actions repeat their body for each result of the action
+type queryPart struct {
+	text     string             // Literal text printed as is
+	jsonpath *jsonpath.JSONPath // Action without filters
+	segments []querySegment     // Action with filters
+	rangeOf  bool               // Whether action is a range
+	body     []queryPart        // Parts between range and end actions
+}
+
+// querySegment is a path followed by a filter, each segment is evaluated on results of the previous one
+type querySegment struct {
+	path   *jsonpath.JSONPath // Path to evaluate, nil if empty
+	filter filterExpr         // Filter applied on results of the path, nil for the last segment
+}
+
+// NewQuery creates new Query with given name
+func NewQuery(name string) *Query {
+	return &Query{
+		name: name,
+	}
+}
+
+// AllowMissingKeys allows missing keys to produce empty output instead of an error
+// It must be called before Parse
+func (q *Query) AllowMissingKeys(allow bool) *Query {
+	q.allowMissingKeys = allow
+	return q
+}
+
+// Parse parses given template
+func (q *Query) Parse(text string) error {
+	q.parts = nil
+	q.jsonpath = q.newJSONPath()
+
+	// Templates without filters are executed by jsonpath package, so all its features, like range, are supported
+	if !strings.Contains(text, filterStart) {
+		return q.jsonpath.Parse(text)
+	}
+
+	parts, err := splitTemplate(text)
+	if err != nil {
+		return err
+	}
+
+	q.parts, parts, err = q.parseParts(parts, false)
+	if err != nil {
+		return err
+	}
+	if len(parts) > 0 {
+		return fmt.Errorf("not in range, nothing to end")
+	}
+
+	return nil
+}
+
+// parseParts parses template parts until end of the template or, inside range, until end action
+// Parts following the end action are returned, so parsing can continue after the range
+func (q *Query) parseParts(parts []templatePart, inRange bool) ([]queryPart, []templatePart, error) {
+	parsed := []queryPart{}
+
+	for len(parts) > 0 {
+		part := parts[0]
+		parts = parts[1:]
+
+		if !part.action {
+			parsed = append(parsed, queryPart{text: part.text})
+			continue
+		}
+
+		action := strings.TrimSpace(part.text)
+		if action == "end" {
+			if !inRange {
+				return nil, nil, fmt.Errorf("not in range, nothing to end")
+			}
+			return parsed, append([]templatePart{part}, parts...), nil
+		}
+
+		fields := strings.Fields(action)
+		rangeOf := len(fields) > 0 && fields[0] == "range"
+		if rangeOf {
+			action = strings.TrimSpace(strings.TrimPrefix(action, "range"))
+		}
+
+		queryPart, err := q.parseAction(action)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if rangeOf {
+			queryPart.rangeOf = true
+			if queryPart.body, parts, err = q.parseParts(parts, true); err != nil {
+				return nil, nil, err
+			}
+			if len(parts) == 0 {
+				return nil, nil, fmt.Errorf("unclosed range, expected end")
+			}
+			// Skip end action closing the range
+			parts = parts[1:]
+		}
+
+		parsed = append(parsed, queryPart)
+	}
+
+	return parsed, nil, nil
+}
+
+// parseAction parses single action, either by jsonpath package or into segments if it contains filters
+func (q *Query) parseAction(action string) (queryPart, error) {
+	if !strings.Contains(action, filterStart) {
+		j := q.newJSONPath()
+		if err := j.Parse("{" + action + "}"); err != nil {
+			return queryPart{}, err
+		}
+		return queryPart{jsonpath: j}, nil
+	}
+
+	segments, err := q.parseSegments(action)
+	if err != nil {
+		return queryPart{}, err
+	}
+
+	return queryPart{segments: segments}, nil
+}
+
+// Execute evaluates template on given data and writes the output
+func (q *Query) Execute(wr io.Writer, data interface{}) error {
+	results, err := q.FindResults(data)
+	if err != nil {
+		return err
+	}
+
+	for _, result := range results {
+		if err := q.PrintResults(wr, result); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// FindResults evaluates template on given data and returns results of each action
+func (q *Query) FindResults(data interface{}) ([][]reflect.Value, error) {
+	if q.jsonpath == nil {
+		return nil, fmt.Errorf("%s is an incomplete jsonpath template", q.name)
+	}
+
+	if q.parts == nil {
+		return q.jsonpath.FindResults(data)
+	}
+
+	return findParts(q.parts, data)
+}
+
+// findParts evaluates parts of template on given data
+// Like in jsonpath package, body of range is evaluated on each result of the range action
+func findParts(parts []queryPart, data interface{}) ([][]reflect.Value, error) {
+	results := [][]reflect.Value{}
+	for _, part := range parts {
+		if part.jsonpath == nil && part.segments == nil {
+			results = append(results, []reflect.Value{reflect.ValueOf(part.text)})
+			continue
+		}
+
+		partResults, err := part.findAction(data)
+		if err != nil {
+			return nil, err
+		}
+
+		if !part.rangeOf {
+			results = append(results, partResults...)
+			continue
+		}
+
+		for _, result := range partResults {
+			for _, value := range result {
+				bodyResults, err := findParts(part.body, value.Interface())
+				if err != nil {
+					return nil, err
+				}
+				results = append(results, bodyResults...)
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// findAction evaluates action of the part on given data
+func (part queryPart) findAction(data interface{}) ([][]reflect.Value, error) {
+	if part.jsonpath != nil {
+		return part.jsonpath.FindResults(data)
+	}
+
+	results, err := part.find(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return [][]reflect.Value{results}, nil
+}
+
+// PrintResults writes results separated by space
+func (q *Query) PrintResults(wr io.Writer, results []reflect.Value) error {
+	return q.jsonpath.PrintResults(wr, results)
+}
+
+// newJSONPath creates jsonpath object with settings of the query
+func (q *Query) newJSONPath() *jsonpath.JSONPath {
+	return jsonpath.New(q.name).AllowMissingKeys(q.allowMissingKeys)
+}
+
+// parseSegments splits action into paths and filters between them
+func (q *Query) parseSegments(action string) ([]querySegment, error) {
+	var segments []querySegment
+
+	for {
+		index := indexOutsideQuotes(action, filterStart)
+		if index < 0 {
+			break
+		}
+
+		end, err := scanFilter(action, index+len(filterStart))
+		if err != nil {
+			return nil, err
+		}
+		if end+1 >= len(action) || action[end+1] != ']' {
+			return nil, fmt.Errorf("unclosed filter, expected ]")
+		}
+
+		path, err := q.parsePath(action[:index])
+		if err != nil {
+			return nil, err
+		}
+
+		filter, err := parseFilter(action[index+len(filterStart) : end])
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter '%s': %v", action[index+len(filterStart):end], err)
+		}
+
+		segments = append(segments, querySegment{path: path, filter: filter})
+		action = action[end+2:]
+	}
+
+	path, err := q.parsePath(action)
+	if err != nil {
+		return nil, err
+	}
+	if path != nil {
+		segments = append(segments, querySegment{path: path})
+	}
+
+	return segments, nil
+}
+
+// parsePath parses part of an action between filters, nil is returned for empty path
+func (q *Query) parsePath(path string) (*jsonpath.JSONPath, error) {
+	path = strings.TrimSpace(path)
+	if path == "" {
+		return nil, nil
+	}
+
+	j := q.newJSONPath()
+	if err := j.Parse("{" + path + "}"); err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// find evaluates segments of the action one after another
+func (part queryPart) find(data interface{}) ([]reflect.Value, error) {
+	values := []reflect.Value{reflect.ValueOf(data)}
+
+	for _, segment := range part.segments {
+		if segment.path != nil {
+			var next []reflect.Value
+			for _, value := range values {
+				if !value.IsValid() {
+					continue
+				}
+				results, err := segment.path.FindResults(value.Interface())
+				if err != nil {
+					return nil, err
+				}
+				for _, result := range results {
+					next = append(next, result...)
+				}
+			}
+			values = next
+		}
+
+		if segment.filter == nil {
+			continue
+		}
+
+		// Filters select matching elements of arrays, other values are selected if they match themselves,
+		// so filters can be applied on validated object, e.g. {$[?(@.spec.replicas > 3)].metadata.name}
+		var next []reflect.Value
+		for _, value := range values {
+			value = indirect(value)
+			if !value.IsValid() {
+				continue
+			}
+
+			elements := []reflect.Value{value}
+			if value.Kind() == reflect.Array || value.Kind() == reflect.Slice {
+				elements = nil
+				for i := 0; i < value.Len(); i++ {
+					elements = append(elements, value.Index(i))
+				}
+			}
+
+			for _, element := range elements {
+				matched, err := segment.filter.match(element)
+				if err != nil {
+					return nil, err
+				}
+				if matched {
+					next = append(next, element)
+				}
+			}
+		}
+		values = next
+	}
+
+	return values, nil
+}
+
+// templatePart is either literal text or action enclosed in braces
+type templatePart struct {
+	text   string // Text of the part, without braces for actions
+	action bool   // Whether part is an action
+}
+
+// splitTemplate splits template into literal text and actions
+func splitTemplate(text string) ([]templatePart, error) {
+	var parts []templatePart
+
+	for text != "" {
+		start := strings.Index(text, "{")
+		if start < 0 {
+			parts = append(parts, templatePart{text: text})
+			break
+		}
+		if start > 0 {
+			parts = append(parts, templatePart{text: text[:start]})
+		}
+
+		end, err := scanAction(text, start+1)
+		if err != nil {
+			return nil, err
+		}
+
+		parts = append(parts, templatePart{text: text[start+1 : end], action: true})
+		text = text[end+1:]
+	}
+
+	return parts, nil
+}
+
+// scanAction returns index of the brace closing action starting at given position
+func scanAction(text string, i int) (int, error) {
+	for ; i < len(text); i++ {
+		switch {
+		case text[i] == '\'' || text[i] == '"':
+			end, err := skipString(text, i)
+			if err != nil {
+				return 0, err
+			}
+			i = end
+		case strings.HasPrefix(text[i:], filterStart):
+			end, err := scanFilter(text, i+len(filterStart))
+			if err != nil {
+				return 0, err
+			}
+			i = end
+		case text[i] == '}':
+			return i, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unclosed action")
+}
+
+// scanFilter returns index of the parenthesis closing filter expression starting at given position
+// Strings and regular expressions are skipped, so they may contain parentheses and braces
+func scanFilter(text string, i int) (int, error) {
+	depth := 1
+	expectRegexp := false
+
+	for ; i < len(text); i++ {
+		switch {
+		case text[i] == ' ' || text[i] == '\t':
+			continue
+		case text[i] == '\'' || text[i] == '"':
+			end, err := skipString(text, i)
+			if err != nil {
+				return 0, err
+			}
+			i = end
+		case text[i] == '/' && expectRegexp:
+			end, err := skipString(text, i)
+			if err != nil {
+				return 0, err
+			}
+			i = end
+		case strings.HasPrefix(text[i:], "=~") || strings.HasPrefix(text[i:], "!~"):
+			i++
+			expectRegexp = true
+			continue
+		case text[i] == '(':
+			depth++
+		case text[i] == ')':
+			depth--
+			if depth == 0 {
+				return i, nil
+			}
+		}
+		expectRegexp = false
+	}
+
+	return 0, fmt.Errorf("unterminated filter")
+}
+
+// skipString returns index of the character closing string, which starts at given position
+// Characters preceded by backslash are skipped
+func skipString(text string, i int) (int, error) {
+	quote := text[i]
+	for j := i + 1; j < len(text); j++ {
+		switch text[j] {
+		case '\\':
+			j++
+		case quote:
+			return j, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unterminated string %s", text[i:])
+}
+
+// indexOutsideQuotes returns index of the first occurrence of substr, which is not quoted
+func indexOutsideQuotes(text, substr string) int {
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\'' || text[i] == '"' {
+			end, err := skipString(text, i)
+			if err != nil {
+				return -1
+			}
+			i = end
+			continue
+		}
+		if strings.HasPrefix(text[i:], substr) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// indirect returns value pointed to by pointers and interfaces
+func indirect(value reflect.Value) reflect.Value {
+	for value.IsValid() && (value.Kind() == reflect.Interface || value.Kind() == reflect.Ptr) {
+		if value.IsNil() {
+			return reflect.Value{}
+		}
+		value = value.Elem()
+	}
+
+	return value
+}
+
+// filterExpr is parsed filter expression, which is evaluated for each filtered element
+type filterExpr interface {
+	match(value reflect.Value) (bool, error)
+}
+
+// filterOr matches if any of expressions matches
+type filterOr struct {
+	left, right filterExpr
+}
+
+func (f filterOr) match(value reflect.Value) (bool, error) {
+	if matched, err := f.left.match(value); err != nil || matched {
+		return matched, err
+	}
+
+	return f.right.match(value)
+}
+
+// filterAnd matches if both expressions match
+type filterAnd struct {
+	left, right filterExpr
+}
+
+func (f filterAnd) match(value reflect.Value) (bool, error) {
+	if matched, err := f.left.match(value); err != nil || !matched {
+		return matched, err
+	}
+
+	return f.right.match(value)
+}
+
+// filterNot matches if expression doesn't match
+type filterNot struct {
+	expr filterExpr
+}
+
+func (f filterNot) match(value reflect.Value) (bool, error) {
+	matched, err := f.expr.match(value)
+	return !matched, err
+}
+
+// filterExists matches if path returns any result
+type filterExists struct {
+	operand filterOperand
+}
+
+func (f filterExists) match(value reflect.Value) (bool, error) {
+	_, found, err := f.operand.resolve(value)
+	return found, err
+}
+
+// filterComparison compares two operands or matches operand with regular expression
+// Comparisons with missing operands don't match, so negated operators match them
+type filterComparison struct {
+	left     filterOperand
+	right    filterOperand
+	operator string
+	regexp   *regexp.Regexp
+}
+
+func (f filterComparison) match(value reflect.Value) (bool, error) {
+	negated := f.operator == "!=" || f.operator == "!~"
+
+	left, found, err := f.left.resolve(value)
+	if err != nil || !found {
+		return negated, err
+	}
+
+	if f.regexp != nil {
+		text, ok := left.(string)
+		if !ok {
+			text = fmt.Sprint(left)
+		}
+		return f.regexp.MatchString(text) != negated, nil
+	}
+
+	right, found, err := f.right.resolve(value)
+	if err != nil || !found {
+		return negated, err
+	}
+
+	return compare(left, right, f.operator), nil
+}
+
+// compare compares two values using given operator
+// Numbers are compared by value, strings lexicographically, other values are only checked for equality
+func compare(left, right interface{}, operator string) bool {
+	if l, ok := toNumber(left); ok {
+		if r, ok := toNumber(right); ok {
+			switch operator {
+			case "==":
+				return l == r
+			case "!=":
+				return l != r
+			case "<":
+				return l < r
+			case "<=":
+				return l <= r
+			case ">":
+				return l > r
+			case ">=":
+				return l >= r
+			}
+		}
+	}
+
+	switch operator {
+	case "==":
+		return reflect.DeepEqual(left, right)
+	case "!=":
+		return !reflect.DeepEqual(left, right)
+	}
+
+	l, ok := left.(string)
+	if !ok {
+		return false
+	}
+	r, ok := right.(string)
+	if !ok {
+		return false
+	}
+
+	switch operator {
+	case "<":
+		return l < r
+	case "<=":
+		return l <= r
+	case ">":
+		return l > r
+	case ">=":
+		return l >= r
+	}
+
+	return false
+}
+
+// toNumber converts numeric values to float64
+func toNumber(value interface{}) (float64, bool) {
+	switch value := value.(type) {
+	case int:
+		return float64(value), true
+	case int32:
+		return float64(value), true
+	case int64:
+		return float64(value), true
+	case float32:
+		return float64(value), true
+	case float64:
+		return value, true
+	}
+
+	return 0, false
+}
+
+// filterOperand is either path relative to filtered element or literal value
+type filterOperand struct {
+	query *Query      // Path relative to filtered element, nil for literals
+	value interface{} // Literal value
+}
+
+// resolve returns value of the operand for given element and whether it has been found
+func (o filterOperand) resolve(value reflect.Value) (interface{}, bool, error) {
+	if o.query == nil {
+		return o.value, true, nil
+	}
+
+	if !value.IsValid() {
+		return nil, false, nil
+	}
+
+	results, err := o.query.FindResults(value.Interface())
+	if err != nil {
+		return nil, false, err
+	}
+
+	var found []reflect.Value
+	for _, result := range results {
+		found = append(found, result...)
+	}
+
+	switch len(found) {
+	case 0:
+		return nil, false, nil
+	case 1:
+		result := indirect(found[0])
+		if !result.IsValid() {
+			return nil, true, nil
+		}
+		return result.Interface(), true, nil
+	default:
+		return nil, false, fmt.Errorf("can only compare one element at a time")
+	}
+}
+
+// Kinds of tokens in filter expressions
+const (
+	tokenPath = iota
+	tokenLiteral
+	tokenRegexp
+	tokenOperator
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenLeftParen
+	tokenRightParen
+)
+
+// filterToken is a single token of filter expression
+type filterToken struct {
+	kind   int            // Kind of the token
+	text   string         // Text of paths and operators
+	value  interface{}    // Value of literals
+	regexp *regexp.Regexp // Compiled regular expression
+}
+
+// Comparison operators supported in filters, longer ones first
+var filterOperators = []string{"==", "!=", "<=", ">=", "=~", "!~", "<", ">"}
+
+// lexFilter splits filter expression into tokens
+func lexFilter(text string) ([]filterToken, error) {
+	var tokens []filterToken
+
+	for i := 0; i < len(text); {
+		c := text[i]
+
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+			continue
+		case c == '(':
+			tokens = append(tokens, filterToken{kind: tokenLeftParen})
+			i++
+			continue
+		case c == ')':
+			tokens = append(tokens, filterToken{kind: tokenRightParen})
+			i++
+			continue
+		case strings.HasPrefix(text[i:], "&&"):
+			tokens = append(tokens, filterToken{kind: tokenAnd})
+			i += 2
+			continue
+		case strings.HasPrefix(text[i:], "||"):
+			tokens = append(tokens, filterToken{kind: tokenOr})
+			i += 2
+			continue
+		}
+
+		if operator := operatorAt(text, i); operator != "" {
+			tokens = append(tokens, filterToken{kind: tokenOperator, text: operator})
+			i += len(operator)
+			continue
+		}
+
+		switch {
+		case c == '!':
+			tokens = append(tokens, filterToken{kind: tokenNot})
+			i++
+		case c == '\'' || c == '"':
+			end, err := skipString(text, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, filterToken{kind: tokenLiteral, value: unescape(text[i+1 : end])})
+			i = end + 1
+		case c == '/':
+			if len(tokens) == 0 || tokens[len(tokens)-1].kind != tokenOperator || !strings.HasSuffix(tokens[len(tokens)-1].text, "~") {
+				return nil, fmt.Errorf("regular expression must follow =~ or !~ operator")
+			}
+			end, err := skipString(text, i)
+			if err != nil {
+				return nil, err
+			}
+			re, err := regexp.Compile(strings.Replace(text[i+1:end], `\/`, "/", -1))
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, filterToken{kind: tokenRegexp, regexp: re})
+			i = end + 1
+		case c == '@':
+			end := scanPath(text, i)
+			tokens = append(tokens, filterToken{kind: tokenPath, text: text[i:end]})
+			i = end
+		case c == '-' || (c >= '0' && c <= '9'):
+			end := i + 1
+			for end < len(text) && strings.IndexByte("0123456789.eE+-", text[end]) >= 0 {
+				end++
+			}
+			number, err := strconv.ParseFloat(text[i:end], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %s", text[i:end])
+			}
+			tokens = append(tokens, filterToken{kind: tokenLiteral, value: number})
+			i = end
+		default:
+			end := i
+			for end < len(text) && (text[end] >= 'a' && text[end] <= 'z') {
+				end++
+			}
+			switch text[i:end] {
+			case "true":
+				tokens = append(tokens, filterToken{kind: tokenLiteral, value: true})
+			case "false":
+				tokens = append(tokens, filterToken{kind: tokenLiteral, value: false})
+			case "null":
+				tokens = append(tokens, filterToken{kind: tokenLiteral, value: nil})
+			default:
+				return nil, fmt.Errorf("unexpected character '%c' at position %d", c, i)
+			}
+			i = end
+		}
+	}
+
+	return tokens, nil
+}
+
+// operatorAt returns comparison operator at given position or empty string
+func operatorAt(text string, i int) string {
+	for _, operator := range filterOperators {
+		if strings.HasPrefix(text[i:], operator) {
+			return operator
+		}
+	}
+
+	return ""
+}
+
+// scanPath returns index of the end of path starting at given position
+// Path ends with whitespace, parenthesis or operator, which are not enclosed in brackets
+func scanPath(text string, i int) int {
+	depth := 0
+
+	for ; i < len(text); i++ {
+		switch c := text[i]; {
+		case c == '\'' || c == '"':
+			end, err := skipString(text, i)
+			if err != nil {
+				return len(text)
+			}
+			i = end
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		case depth == 0 && strings.IndexByte(" \t)=!<>&|", c) >= 0:
+			return i
+		}
+	}
+
+	return i
+}
+
+// unescape removes backslashes escaping characters in string literals
+func unescape(text string) string {
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\\' && i+1 < len(text) {
+			i++
+		}
+		b.WriteByte(text[i])
+	}
+
+	return b.String()
+}
+
+// filterParser builds filter expression from tokens using recursive descent
+// Operator precedence from lowest: ||, &&, !, comparisons
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+// parseFilter parses filter expression, e.g. @.name != 'foo' && @.image =~ /^registry\.corp\//
+func parseFilter(text string) (filterExpr, error) {
+	tokens, err := lexFilter(text)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &filterParser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected token at the end of expression")
+	}
+
+	return expr, nil
+}
+
+// peek checks if next token is of given kind
+func (p *filterParser) peek(kind int) bool {
+	return p.pos < len(p.tokens) && p.tokens[p.pos].kind == kind
+}
+
+func (p *filterParser) parseOr() (filterExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek(tokenOr) {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = filterOr{left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek(tokenAnd) {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = filterAnd{left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (filterExpr, error) {
+	switch {
+	case p.peek(tokenNot):
+		p.pos++
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return filterNot{expr: expr}, nil
+	case p.peek(tokenLeftParen):
+		p.pos++
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peek(tokenRightParen) {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		p.pos++
+		return expr, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (filterExpr, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.peek(tokenOperator) {
+		if left.query == nil {
+			return nil, fmt.Errorf("literal must be compared with a path")
+		}
+		return filterExists{operand: left}, nil
+	}
+
+	operator := p.tokens[p.pos].text
+	p.pos++
+
+	if operator == "=~" || operator == "!~" {
+		if !p.peek(tokenRegexp) {
+			return nil, fmt.Errorf("operator %s must be followed by regular expression, e.g. /^foo$/", operator)
+		}
+		re := p.tokens[p.pos].regexp
+		p.pos++
+		return filterComparison{left: left, operator: operator, regexp: re}, nil
+	}
+
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	return filterComparison{left: left, right: right, operator: operator}, nil
+}
+
+func (p *filterParser) parseOperand() (filterOperand, error) {
+	if p.pos >= len(p.tokens) {
+		return filterOperand{}, fmt.Errorf("unexpected end of expression")
+	}
+
+	token := p.tokens[p.pos]
+	switch token.kind {
+	case tokenPath:
+		p.pos++
+		query := NewQuery(token.text).AllowMissingKeys(true)
+		if err := query.Parse("{" + token.text + "}"); err != nil {
+			return filterOperand{}, err
+		}
+		return filterOperand{query: query}, nil
+	case tokenLiteral:
+		p.pos++
+		return filterOperand{value: token.value}, nil
+	}
+
+	return filterOperand{}, fmt.Errorf("expected path or literal")
+}
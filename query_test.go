@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	jsonpath "k8s.io/client-go/util/jsonpath"
+)
+
+const queryTestObject = `{
+  "metadata": {
+    "name": "foo",
+    "labels": {"app": "web", "tier": "frontend"},
+    "annotations": {"seccomp.security.alpha.kubernetes.io/defaultProfileName": "unconfined"}
+  },
+  "spec": {
+    "replicas": 5,
+    "containers": [
+      {"name": "web", "image": "registry.corp/web:1.0", "ports": [{"containerPort": 8080}]},
+      {"name": "sidecar", "image": "docker.io/proxy:2.0", "ports": [{"containerPort": 80}], "securityContext": {"privileged": true}},
+      {"name": "debug", "image": "docker.io/busybox"}
+    ]
+  }
+}`
+
+func executeQuery(t *testing.T, text string) string {
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(queryTestObject), &object); err != nil {
+		t.Fatalf("Deserializing should not fail: %s", err)
+	}
+
+	query := NewQuery("test").AllowMissingKeys(true)
+	if err := query.Parse(text); err != nil {
+		t.Fatalf("Parsing query '%s' shouldn't fail: %s", text, err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := query.Execute(buf, object); err != nil {
+		t.Fatalf("Executing query '%s' shouldn't fail: %s", text, err)
+	}
+
+	return buf.String()
+}
+
+func TestQueryFilters(t *testing.T) {
+	queries := map[string]string{
+		// Queries without filters are executed by jsonpath package
+		"{.metadata.name}":                             "foo",
+		"{range .spec.containers[*]}{.name},{end}":     "web,sidecar,debug,",
+		"{.spec.containers[?(@.name == 'web')].image}": "registry.corp/web:1.0",
+		// Negation, with missing values matching
+		"{.spec.containers[?(@.securityContext.privileged != true)].name}": "web debug",
+		// Regular expressions
+		`{.spec.containers[?(@.image =~ /^registry\.corp\//)].name}`: "web",
+		`{.spec.containers[?(@.image !~ /^registry\.corp\//)].name}`: "sidecar debug",
+		`{.spec.containers[?(@.image =~ /:[0-9]{1}\.0$/)].name}`:     "web sidecar",
+		// Logical operators
+		`{.spec.containers[?(@.image !~ /^registry\.corp\// && @.name != 'sidecar')].name}`:   "debug",
+		`{.spec.containers[?(@.name == 'web' || @.securityContext.privileged == true)].name}`: "web sidecar",
+		`{.spec.containers[?(!(@.name == 'web' || @.name == 'debug'))].name}`:                 "sidecar",
+		"{.spec.containers[?(@.securityContext)].name}":                                       "sidecar",
+		"{.spec.containers[?(!@.securityContext)].name}":                                      "web debug",
+		// Numeric and string comparisons
+		"{.spec.containers[?(@.ports[0].containerPort < 1024)].name}":  "sidecar",
+		"{.spec.containers[?(@.ports[0].containerPort >= 8080)].name}": "web",
+		"{.spec.containers[?(@.name > 'debug')].name}":                 "web sidecar",
+		// Filters applied on objects select them if they match
+		"{$[?(@.spec.replicas > 3)].metadata.name}":                                  "foo",
+		"{$[?(@.spec.replicas > 5)].metadata.name}":                                  "",
+		"{.metadata.labels[?(@.app == 'web' && @.tier =~ /^front/)].app}":            "web",
+		"{.spec.containers[*][?(@.name == 'debug')].image}":                          "docker.io/busybox",
+		"name={.metadata.name} debug={.spec.containers[?(@.name == 'debug')].image}": "name=foo debug=docker.io/busybox",
+		// Single expression form of default seccomp rule
+		`{$[?(@.metadata.annotations['seccomp\.security\.alpha\.kubernetes\.io/defaultProfileName'] =~ /^(unconfined|\*)?$/)].metadata.name}`: "foo",
+	}
+
+	for text, expected := range queries {
+		if output := executeQuery(t, text); output != expected {
+			t.Errorf("Expected output of query '%s' to be '%s', got: '%s'", text, expected, output)
+		}
+	}
+}
+
+func TestQueryClientGoCompatibility(t *testing.T) {
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(queryTestObject), &object); err != nil {
+		t.Fatalf("Deserializing should not fail: %s", err)
+	}
+
+	// Templates with filters supported by jsonpath package must produce the same output
+	queries := map[string]string{
+		"{.spec.containers[?(@.name == 'web')].image}":                                        "registry.corp/web:1.0",
+		"{.spec.containers[?(@.securityContext)].name}":                                       "sidecar",
+		"{.spec.containers[?(@.ports[0].containerPort < 1024.0)].name}":                       "sidecar",
+		"{range .spec.containers[?(@.name == 'web')]}{.name}{end}":                            "web",
+		"{range .spec.containers[?(@.securityContext)]}{.name}={.image};{end}":                "sidecar=docker.io/proxy:2.0;",
+		"{range .spec.containers[*]}{.name}:{.ports[?(@.containerPort)].containerPort},{end}": "web:8080,sidecar:80,debug:,",
+		"{range .spec.containers[?(@.ports)]}{range .ports[*]}{.containerPort} {end}{end}":    "8080 80 ",
+		"{.metadata.name} {.spec.containers[?(@.name == 'debug')].image} {.spec.replicas}":    "foo docker.io/busybox 5",
+	}
+
+	for text, expected := range queries {
+		j := jsonpath.New("test").AllowMissingKeys(true)
+		if err := j.Parse(text); err != nil {
+			t.Fatalf("Parsing query '%s' by jsonpath package shouldn't fail: %s", text, err)
+		}
+		buf := new(bytes.Buffer)
+		if err := j.Execute(buf, object); err != nil {
+			t.Fatalf("Executing query '%s' by jsonpath package shouldn't fail: %s", text, err)
+		}
+		if buf.String() != expected {
+			t.Fatalf("Expected output of query '%s' by jsonpath package to be '%s', got: '%s'", text, expected, buf.String())
+		}
+
+		if output := executeQuery(t, text); output != expected {
+			t.Errorf("Expected output of query '%s' to be '%s' as in jsonpath package, got: '%s'", text, expected, output)
+		}
+	}
+}
+
+func TestQueryIntegerComparison(t *testing.T) {
+	object := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"replicas": int64(3),
+		},
+	}
+
+	query := NewQuery("TestQueryIntegerComparison")
+	if err := query.Parse("{$[?(@.spec.replicas == 3 && @.spec.replicas > 2.5)].spec.replicas}"); err != nil {
+		t.Fatalf("Parsing query shouldn't fail: %s", err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := query.Execute(buf, object); err != nil {
+		t.Fatalf("Executing query shouldn't fail: %s", err)
+	}
+
+	if buf.String() != "3" {
+		t.Errorf("Integers should be compared with float literals, got: '%s'", buf.String())
+	}
+}
+
+func TestQueryFindResults(t *testing.T) {
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(queryTestObject), &object); err != nil {
+		t.Fatalf("Deserializing should not fail: %s", err)
+	}
+
+	query := NewQuery("TestQueryFindResults")
+	if err := query.Parse("{.spec.containers[?(@.name != 'web')].image}"); err != nil {
+		t.Fatalf("Parsing query shouldn't fail: %s", err)
+	}
+
+	results, err := query.FindResults(object)
+	if err != nil {
+		t.Fatalf("Executing query shouldn't fail: %s", err)
+	}
+
+	if len(results) != 1 || len(results[0]) != 2 {
+		t.Fatalf("Expected 2 results of single action, got: %v", results)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := query.PrintResults(buf, results[0][1:]); err != nil {
+		t.Fatalf("Printing results shouldn't fail: %s", err)
+	}
+
+	if buf.String() != "docker.io/busybox" {
+		t.Errorf("Expected single printed result, got: '%s'", buf.String())
+	}
+}
+
+func TestQueryParseErrors(t *testing.T) {
+	queries := []string{
+		"{.spec.containers[?(@.name == 'web']}",
+		"{.spec.containers[?(@.name == 'web')}",
+		"{.spec.containers[?(@.name =~ 'web')].name}",
+		"{.spec.containers[?(@.name == )].name}",
+		"{.spec.containers[?(@.name == 'web' &&)].name}",
+		"{.spec.containers[?('web')].name}",
+		"{.spec.containers[?(@.name =~ /[/)].name}",
+		"{.spec.containers[?((@.name == 'web')].name}",
+		"{.spec.containers[?(@.name == web)].name}",
+		"{range .spec.containers[?(@.name == 'web')]}{.name}",
+		"{.spec.containers[?(@.name == 'web')].name}{end}",
+		"{range .spec.containers[?(@.name == 'web')]}{.name}{end}{end}",
+	}
+
+	for _, text := range queries {
+		if err := NewQuery("TestQueryParseErrors").Parse(text); err == nil {
+			t.Errorf("Parsing invalid query '%s' should fail", text)
+		}
+	}
+}
+
+func TestQueryCompareMultipleValues(t *testing.T) {
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(queryTestObject), &object); err != nil {
+		t.Fatalf("Deserializing should not fail: %s", err)
+	}
+
+	query := NewQuery("TestQueryCompareMultipleValues")
+	if err := query.Parse("{$[?(@.spec.containers[*].name == 'web')].metadata.name}"); err != nil {
+		t.Fatalf("Parsing query shouldn't fail: %s", err)
+	}
+
+	if err := query.Execute(new(bytes.Buffer), object); err == nil {
+		t.Errorf("Comparing multiple values at once should fail")
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/rego"
+)
+
+// Name of the rule in Rego module, which produces set of rejection messages
+const regoDenyRule = "deny"
+
+// Maximum time of evaluating single Rego policy
+const regoTimeout = 5 * time.Second
+
+// Builtins, which are not allowed in policies, as validation must not depend on external services
+var regoUnsafeBuiltins = map[string]struct{}{
+	"http.send":          {},
+	"net.lookup_ip_addr": {},
+	"opa.runtime":        {},
+}
+
+// compileRego parses given Rego module and prepares query of its deny rule for evaluation
+// Modules using syntax of OPA older than 1.0, e.g. deny[msg] { ... }, are accepted as well
+func compileRego(filename, module string) (*rego.PreparedEvalQuery, error) {
+	version := ast.RegoV1
+	parsed, err := ast.ParseModuleWithOpts(filename, module, ast.ParserOptions{RegoVersion: version})
+	if err != nil {
+		version = ast.RegoV0
+		if parsed, err = ast.ParseModuleWithOpts(filename, module, ast.ParserOptions{RegoVersion: version}); err != nil {
+			return nil, err
+		}
+	}
+
+	// Module without deny rule would allow any object, which is most likely a mistake, e.g. typo in rule name
+	if !definesRule(parsed, regoDenyRule) {
+		return nil, fmt.Errorf("Rego module must define '%s' rule", regoDenyRule)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), regoTimeout)
+	defer cancel()
+
+	query, err := rego.New(
+		rego.Query(fmt.Sprintf("%s.%s", parsed.Package.Path, regoDenyRule)),
+		rego.Module(filename, module),
+		rego.SetRegoVersion(version),
+		rego.UnsafeBuiltins(regoUnsafeBuiltins),
+	).PrepareForEval(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &query, nil
+}
+
+// definesRule checks if module defines rule with given name, e.g. deny contains msg if { ... } or deny.foo := ...
+func definesRule(module *ast.Module, name string) bool {
+	for _, rule := range module.Rules {
+		if rule.Head.Ref()[0].Equal(ast.VarTerm(name)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// evalRego evaluates deny rule of prepared policy with AdmissionReview as input and returns its messages
+// Policy without deny rule matching the input returns no messages
+func evalRego(query *rego.PreparedEvalQuery, req *ValidationRequest) ([]string, error) {
+	request := req.attributes()
+	request["object"] = req.Object
+	request["oldObject"] = req.OldObject
+
+	input := map[string]interface{}{
+		"apiVersion": "admission.k8s.io/v1",
+		"kind":       "AdmissionReview",
+		"request":    request,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), regoTimeout)
+	defer cancel()
+
+	results, err := query.Eval(ctx, rego.EvalInput(input))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(results) == 0 || len(results[0].Expressions) == 0 {
+		return nil, nil
+	}
+
+	denials, ok := results[0].Expressions[0].Value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s rule returned %v instead of set", regoDenyRule, results[0].Expressions[0].Value)
+	}
+
+	var messages []string
+	for _, denial := range denials {
+		// Messages may also be objects, e.g. {"msg": "..."}, then they are returned as JSON
+		message, ok := denial.(string)
+		if !ok {
+			encoded, err := json.Marshal(denial)
+			if err != nil {
+				return nil, err
+			}
+			message = string(encoded)
+		}
+		messages = append(messages, message)
+	}
+
+	return messages, nil
+}
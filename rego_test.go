@@ -0,0 +1,147 @@
+package main
+
+import (
+	"sort"
+	"testing"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const regoTestPolicy = `package kubernetes.admission
+
+deny contains msg if {
+	input.request.kind.kind == "Pod"
+	some container in input.request.object.spec.containers
+	not startswith(container.image, "registry.corp/")
+	msg := sprintf("Image %s of container %s must come from registry.corp", [container.image, container.name])
+}
+
+deny contains msg if {
+	input.request.operation == "UPDATE"
+	input.request.object.metadata.labels.team != input.request.oldObject.metadata.labels.team
+	msg := sprintf("Label team can't be changed by %s", [input.request.userInfo.username])
+}
+`
+
+func regoTestRequest() *ValidationRequest {
+	return &ValidationRequest{
+		UID:       "TestRego",
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+		Name:      "foo",
+		Namespace: "prod",
+		Operation: "UPDATE",
+		UserInfo:  authenticationv1.UserInfo{Username: "alice"},
+		Object: map[string]interface{}{
+			"metadata": map[string]interface{}{"labels": map[string]interface{}{"team": "a"}},
+			"spec": map[string]interface{}{
+				"containers": []interface{}{
+					map[string]interface{}{"name": "web", "image": "registry.corp/web"},
+					map[string]interface{}{"name": "proxy", "image": "docker.io/proxy"},
+				},
+			},
+		},
+		OldObject: map[string]interface{}{
+			"metadata": map[string]interface{}{"labels": map[string]interface{}{"team": "b"}},
+		},
+	}
+}
+
+func TestEvalRego(t *testing.T) {
+	query, err := compileRego("policy.rego", regoTestPolicy)
+	if err != nil {
+		t.Fatalf("Compiling policy shouldn't fail: %s", err)
+	}
+
+	messages, err := evalRego(query, regoTestRequest())
+	if err != nil {
+		t.Fatalf("Evaluating policy shouldn't fail: %s", err)
+	}
+
+	sort.Strings(messages)
+	expected := []string{
+		"Image docker.io/proxy of container proxy must come from registry.corp",
+		"Label team can't be changed by alice",
+	}
+	if len(messages) != len(expected) || messages[0] != expected[0] || messages[1] != expected[1] {
+		t.Errorf("Expected messages %v, got: %v", expected, messages)
+	}
+}
+
+func TestEvalRegoV0Syntax(t *testing.T) {
+	policy := `package kubernetes.admission
+
+deny[msg] {
+	input.request.name == "foo"
+	msg := "Name foo is reserved"
+}
+`
+	query, err := compileRego("policy.rego", policy)
+	if err != nil {
+		t.Fatalf("Compiling policy using syntax of OPA older than 1.0 shouldn't fail: %s", err)
+	}
+
+	messages, err := evalRego(query, regoTestRequest())
+	if err != nil {
+		t.Fatalf("Evaluating policy shouldn't fail: %s", err)
+	}
+	if len(messages) != 1 || messages[0] != "Name foo is reserved" {
+		t.Errorf("Expected single message, got: %v", messages)
+	}
+}
+
+func TestEvalRegoNoDenials(t *testing.T) {
+	policies := []string{
+		// Deny rule not matching the input
+		"package foo\n\ndeny contains msg if {\n\tinput.request.name == \"bar\"\n\tmsg := \"bar\"\n}\n",
+	}
+
+	for _, policy := range policies {
+		query, err := compileRego("policy.rego", policy)
+		if err != nil {
+			t.Fatalf("Compiling policy shouldn't fail: %s", err)
+		}
+
+		messages, err := evalRego(query, regoTestRequest())
+		if err != nil {
+			t.Fatalf("Evaluating policy shouldn't fail: %s", err)
+		}
+		if len(messages) != 0 {
+			t.Errorf("Policy without matching deny rule shouldn't return messages, got: %v", messages)
+		}
+	}
+}
+
+func TestEvalRegoObjectMessage(t *testing.T) {
+	policy := "package foo\n\ndeny contains {\"msg\": \"denied\"} if input.request.name == \"foo\"\n"
+	query, err := compileRego("policy.rego", policy)
+	if err != nil {
+		t.Fatalf("Compiling policy shouldn't fail: %s", err)
+	}
+
+	messages, err := evalRego(query, regoTestRequest())
+	if err != nil {
+		t.Fatalf("Evaluating policy shouldn't fail: %s", err)
+	}
+	if len(messages) != 1 || messages[0] != `{"msg":"denied"}` {
+		t.Errorf("Messages which are not strings should be returned as JSON, got: %v", messages)
+	}
+}
+
+func TestCompileRegoErrors(t *testing.T) {
+	policies := map[string]string{
+		"invalid syntax":   "package foo\n\ndeny contains msg if {\n",
+		"missing package":  "deny contains msg if { msg := \"foo\" }\n",
+		"unsafe builtin":   "package foo\n\ndeny contains msg if {\n\thttp.send({\"method\": \"get\", \"url\": \"http://example.com\"})\n\tmsg := \"foo\"\n}\n",
+		"undefined symbol": "package foo\n\ndeny contains msg if {\n\tmsg := unknown_function(input)\n}\n",
+		"no deny rule":     "package k8s\n\nallow := true\n",
+		"typo in deny":     "package k8s\n\ndenny contains msg if {\n\tmsg := \"foo\"\n}\n",
+		"no rules":         "package k8s\n",
+	}
+
+	for name, policy := range policies {
+		if _, err := compileRego("policy.rego", policy); err == nil {
+			t.Errorf("Compiling policy with %s should fail", name)
+		}
+	}
+}
@@ -10,6 +10,7 @@ kubectl auth reconcile -f k8s/cluster-psp/role-bindings.yaml
 kubectl apply -f k8s/validating-admission-webhook/01-namespace.yaml
 kubectl apply -f k8s/validating-admission-webhook/02-service.yaml
 kubectl apply -f k8s/validating-admission-webhook/03-psp.yaml
+kubectl auth reconcile -f k8s/validating-admission-webhook/03-rbac.yaml
 kubectl apply -f k8s/validating-admission-webhook/04-config.yaml
 kubectl apply -f k8s/validating-admission-webhook/05-deployment.yaml
 
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// Name of the rule validating objects against schema of their kind
+const schemaRuleName = "schema"
+
+// Printer of schema error messages
+var schemaPrinter = message.NewPrinter(language.English)
+
+// SchemaError describes single constraint of schema violated by validated object
+type SchemaError struct {
+	Pointer string // JSON pointer of invalid field, empty for the whole object
+	Message string // Description of violated constraint
+}
+
+// compileSchema compiles JSON Schema or OpenAPI v3 schema given as JSON document
+// Location is used for resolving relative references and in error messages
+func compileSchema(location string, document []byte) (*jsonschema.Schema, error) {
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(document))
+	if err != nil {
+		return nil, err
+	}
+
+	compiler := jsonschema.NewCompiler()
+	if err := compiler.AddResource(location, doc); err != nil {
+		return nil, err
+	}
+
+	return compiler.Compile(location)
+}
+
+// validateSchema validates object against compiled schema and returns error for each violated constraint
+func validateSchema(schema *jsonschema.Schema, object interface{}) ([]SchemaError, error) {
+	err := schema.Validate(object)
+	if err == nil {
+		return nil, nil
+	}
+
+	validationError, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return nil, err
+	}
+
+	return schemaErrors(validationError), nil
+}
+
+// schemaErrors flattens tree of validation errors into its leaves, which describe violated constraints,
+// while inner nodes only group them, e.g. by keyword like properties or allOf
+func schemaErrors(err *jsonschema.ValidationError) []SchemaError {
+	if len(err.Causes) == 0 {
+		return []SchemaError{{
+			Pointer: jsonPointer(err.InstanceLocation),
+			Message: err.ErrorKind.LocalizedString(schemaPrinter),
+		}}
+	}
+
+	var errors []SchemaError
+	for _, cause := range err.Causes {
+		errors = append(errors, schemaErrors(cause)...)
+	}
+	return errors
+}
+
+// jsonPointer converts location of a value into JSON pointer as defined in RFC 6901, e.g. /spec/containers/0
+func jsonPointer(tokens []string) string {
+	escaper := strings.NewReplacer("~", "~0", "/", "~1")
+
+	pointer := ""
+	for _, token := range tokens {
+		pointer += fmt.Sprintf("/%s", escaper.Replace(token))
+	}
+	return pointer
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const schemaTestDocument = `{
+	"type": "object",
+	"required": ["spec"],
+	"properties": {
+		"spec": {
+			"type": "object",
+			"required": ["owner"],
+			"properties": {
+				"owner": {"type": "string", "pattern": "^team-"},
+				"replicas": {"type": "integer", "minimum": 1},
+				"settings": {
+					"type": "object",
+					"additionalProperties": {"type": "string"}
+				}
+			}
+		}
+	}
+}`
+
+func TestValidateSchema(t *testing.T) {
+	schema, err := compileSchema("test.schema.json", []byte(schemaTestDocument))
+	if err != nil {
+		t.Fatalf("Compiling schema shouldn't fail: %s", err)
+	}
+
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"spec":{"owner":"alice","replicas":0,"settings":{"a/b":1,"c":"d"}}}`), &object); err != nil {
+		t.Fatalf("Deserializing should not fail: %s", err)
+	}
+
+	errors, err := validateSchema(schema, object)
+	if err != nil {
+		t.Fatalf("Validating object shouldn't fail: %s", err)
+	}
+
+	pointers := map[string]bool{}
+	for _, schemaError := range errors {
+		if schemaError.Message == "" {
+			t.Errorf("Schema error at '%s' should have a message", schemaError.Pointer)
+		}
+		pointers[schemaError.Pointer] = true
+	}
+	for _, pointer := range []string{"/spec/owner", "/spec/replicas", "/spec/settings/a~1b"} {
+		if !pointers[pointer] {
+			t.Errorf("Expected schema error at '%s', got: %+v", pointer, errors)
+		}
+	}
+	if len(errors) != 3 {
+		t.Errorf("Expected 3 schema errors, got: %+v", errors)
+	}
+}
+
+func TestValidateSchemaRoot(t *testing.T) {
+	schema, err := compileSchema("test.schema.json", []byte(schemaTestDocument))
+	if err != nil {
+		t.Fatalf("Compiling schema shouldn't fail: %s", err)
+	}
+
+	errors, err := validateSchema(schema, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("Validating object shouldn't fail: %s", err)
+	}
+	if len(errors) != 1 || errors[0].Pointer != "" {
+		t.Errorf("Missing required field should be reported on the whole object, got: %+v", errors)
+	}
+}
+
+func TestValidateSchemaValid(t *testing.T) {
+	schema, err := compileSchema("test.schema.json", []byte(schemaTestDocument))
+	if err != nil {
+		t.Fatalf("Compiling schema shouldn't fail: %s", err)
+	}
+
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"spec":{"owner":"team-a","replicas":3,"settings":{"c":"d"}}}`), &object); err != nil {
+		t.Fatalf("Deserializing should not fail: %s", err)
+	}
+
+	errors, err := validateSchema(schema, object)
+	if err != nil {
+		t.Fatalf("Validating object shouldn't fail: %s", err)
+	}
+	if len(errors) != 0 {
+		t.Errorf("Valid object shouldn't have schema errors, got: %+v", errors)
+	}
+}
+
+func TestCompileSchemaErrors(t *testing.T) {
+	documents := map[string]string{
+		"invalid JSON":      `{"type": "object"`,
+		"invalid keyword":   `{"type": "foo"}`,
+		"invalid pattern":   `{"pattern": "(foo"}`,
+		"missing reference": `{"$ref": "#/definitions/missing"}`,
+	}
+
+	for name, document := range documents {
+		if _, err := compileSchema("test.schema.json", []byte(document)); err == nil {
+			t.Errorf("Compiling schema with %s should fail", name)
+		}
+	}
+}
+
+func TestJSONPointer(t *testing.T) {
+	pointers := map[string][]string{
+		"":                              nil,
+		"/spec/containers/0":            {"spec", "containers", "0"},
+		"/metadata/annotations/a~1b~0c": {"metadata", "annotations", "a/b~c"},
+	}
+
+	for expected, tokens := range pointers {
+		if pointer := jsonPointer(tokens); pointer != expected {
+			t.Errorf("Expected pointer '%s' for %v, got: '%s'", expected, tokens, pointer)
+		}
+	}
+}
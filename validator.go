@@ -3,43 +3,144 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"path"
+	"reflect"
 	"regexp"
 	"strings"
+	"text/template"
+	"text/template/parse"
 
+	"cel.dev/cel-go/cel"
 	"github.com/golang/glog"
-	jsonpath "k8s.io/client-go/util/jsonpath"
+	"github.com/open-policy-agent/opa/v1/rego"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
+// Wildcard matching any group or version of the kind
+const wildcard = "*"
+
+// Supported rule match modes
+const (
+	matchForbidden = "forbidden" // Reject object if query output matches regexp
+	matchRequired  = "required"  // Reject object if query output does not match regexp
+)
+
+// Supported rule types
+const (
+	typeMatch     = "match"     // Check output of JSONPath query executed on validated object
+	typeImmutable = "immutable" // Compare outputs of JSONPath query executed on old and new object
+	typeCEL       = "cel"       // Evaluate CEL expression, which returns true for valid objects
+	typeRego      = "rego"      // Evaluate deny rule of Rego policy, which returns rejection messages
+	typeSchema    = "schema"    // Validate object against schema of the kind, only used for rule created from the schema
+)
+
+// Supported rule enforcement modes
+const (
+	enforcementDeny  = "deny"  // Reject objects violating the rule
+	enforcementAudit = "audit" // Only log and record violations, but accept objects
+	enforcementWarn  = "warn"  // Accept objects, but return violations as warnings to the user
+)
+
+// Prefix of usernames of service accounts
+const serviceAccountPrefix = "system:serviceaccount:"
+
+// Operations, which rules apply to, if not specified otherwise
+var defaultOperations = map[string]bool{string(admissionv1.Create): true, string(admissionv1.Update): true}
+
 // Validator keeps map of supported kinds and their rules
 type Validator struct {
-	rules map[string][]ValidatorRule
+	rules map[metav1.GroupVersionKind][]ValidatorRule
 }
 
 // ValidatorRule stores parsed version of ConfigRule
 type ValidatorRule struct {
-	jsonpath *jsonpath.JSONPath // Parsed JSONPath object
-	regexp   *regexp.Regexp     // Compiled Regexp
-	message  string             // Error message in case of rejection
-	name     string             // Rule name
+	immutable             bool                    // Whether query output must not change on update instead of being checked
+	expression            cel.Program             // Compiled CEL expression, used instead of JSONPath query if set
+	expressionNamespace   bool                    // Whether CEL expression refers to namespace object
+	policy                *rego.PreparedEvalQuery // Prepared query of Rego policy deny rule, used instead of JSONPath query if set
+	schema                *jsonschema.Schema      // Compiled schema of the kind, used instead of JSONPath query if set
+	condition             *ruleCondition          // Tree of conditions of composite rule, used instead of JSONPath query if set
+	operations            map[string]bool         // Operations the rule applies to
+	namespaces            []string                // Glob patterns of namespaces the rule applies to
+	excludeNamespaces     []string                // Glob patterns of namespaces the rule doesn't apply to
+	namespaceSelector     labels.Selector         // Selector of namespace labels, nil if rule applies to namespaces with any labels
+	objectSelector        labels.Selector         // Selector of object labels, nil if rule applies to objects with any labels
+	exemptUsers           []string                // Glob patterns of users exempted from the rule
+	exemptGroups          []string                // Glob patterns of groups exempted from the rule
+	exemptServiceAccounts []string                // Glob patterns of service accounts exempted from the rule, in namespace/name format
+	jsonpath              *Query                  // Parsed JSONPath query
+	path                  string                  // JSONPath query as defined in config
+	regexp                *regexp.Regexp          // Compiled Regexp
+	required              bool                    // Whether query output must match regexp instead of not matching it
+	forEach               bool                    // Whether each query result should be checked separately
+	enforcement           string                  // What to do when object violates the rule
+	message               *template.Template      // Template of error message in case of rejection
+	name                  string                  // Rule name
 }
 
 // NewValidator creates new instance of Validator struct
 func NewValidator() *Validator {
-	rules := make(map[string][]ValidatorRule)
+	rules := make(map[metav1.GroupVersionKind][]ValidatorRule)
 	return &Validator{
 		rules: rules,
 	}
 }
 
+// Checks if given operations are supported and converts them into a set
+func parseOperations(operations []string) (map[string]bool, error) {
+	parsed := make(map[string]bool)
+	for _, operation := range operations {
+		switch admissionv1.Operation(operation) {
+		case admissionv1.Create, admissionv1.Update, admissionv1.Delete, admissionv1.Connect:
+			parsed[operation] = true
+		default:
+			return nil, fmt.Errorf("Unsupported operation '%s', expected one of '%s', '%s', '%s' or '%s'",
+				operation, admissionv1.Create, admissionv1.Update, admissionv1.Delete, admissionv1.Connect)
+		}
+	}
+
+	return parsed, nil
+}
+
 // AddRule parses given ConfigRule's jsonpath and regexp and adds it to validator
-func (v *Validator) AddRule(kind string, rule ConfigRule) error {
-	glog.Infof("Parsing rule '%s' for kind '%s': JSONPath=%s Regexp=%s", rule.Name, kind, rule.Jsonpath, rule.Regexp)
+// Group and version of given kind may be set to wildcard to match any group or version
+// Rule applies to given operations of the kind, unless it defines its own, or to CREATE and UPDATE if both are empty
+func (v *Validator) AddRule(kind metav1.GroupVersionKind, kindOperations []string, rule ConfigRule) error {
+	glog.Infof("Parsing rule '%s' for kind '%s': Type=%s Operations=%v JSONPath=%s CEL=%s Regexp=%s Match=%s ForEach=%t Enforcement=%s Namespaces=%v ExcludeNamespaces=%v",
+		rule.Name, kind, rule.Type, rule.Operations, rule.Jsonpath, rule.Cel, rule.Regexp, rule.Match, rule.ForEach, rule.Enforcement, rule.Namespaces, rule.ExcludeNamespaces)
 
-	if kind == "" {
+	if kind.Kind == "" {
 		return fmt.Errorf("Kind can't be empty")
 	}
 
-	if rule.Jsonpath == "" {
+	// Rules with CEL expression or Rego policy are of that type, unless type is set explicitly
+	ruleType := rule.Type
+	switch {
+	case ruleType == "" && rule.Cel != "":
+		ruleType = typeCEL
+	case ruleType == "" && (rule.Rego != "" || rule.RegoFile != ""):
+		ruleType = typeRego
+	}
+
+	// Composite rules combine conditions instead of checking single JSONPath query,
+	// rule itself is the root of condition tree, so it must not check JSONPath query at the same time
+	condition := Condition{
+		Jsonpath: rule.Jsonpath,
+		Regexp:   rule.Regexp,
+		Match:    rule.Match,
+		AllOf:    rule.AllOf,
+		AnyOf:    rule.AnyOf,
+		Not:      rule.Not,
+	}
+	composite := condition.isComposite()
+
+	if rule.Jsonpath == "" && !composite && ruleType != typeCEL && ruleType != typeRego && ruleType != typeSchema {
 		return fmt.Errorf("JSONPath can't be empty")
 	}
 
@@ -47,17 +148,186 @@ func (v *Validator) AddRule(kind string, rule ConfigRule) error {
 		return fmt.Errorf("Rule name can't be empty")
 	}
 
-	// Create JSONPath object
-	jsonpath := jsonpath.New(fmt.Sprintf("%s %s", kind, rule.Name))
-	jsonpath.AllowMissingKeys(true)
-	if err := jsonpath.Parse(rule.Jsonpath); err != nil {
+	// Parse message template and make sure it refers only to existing fields
+	message, err := parseMessage(rule.Name, rule.Message)
+	if err != nil {
 		return err
 	}
 
 	validator_rule := ValidatorRule{
-		jsonpath: jsonpath,
-		message:  rule.Message,
-		name:     rule.Name,
+		path:    rule.Jsonpath,
+		forEach: rule.ForEach,
+		message: message,
+		name:    rule.Name,
+	}
+
+	if len(kindOperations) > 0 {
+		if validator_rule.operations, err = parseOperations(kindOperations); err != nil {
+			return fmt.Errorf("Invalid operations of kind: %s", err)
+		}
+	}
+
+	if rule.Cel != "" && ruleType != typeCEL {
+		return fmt.Errorf("CEL expression can only be used with '%s' rules", typeCEL)
+	}
+
+	if (rule.Rego != "" || rule.RegoFile != "") && ruleType != typeRego {
+		return fmt.Errorf("Rego policy can only be used with '%s' rules", typeRego)
+	}
+
+	if composite && ruleType != "" && ruleType != typeMatch {
+		return fmt.Errorf("AllOf, anyOf and not can only be used with '%s' rules", typeMatch)
+	}
+
+	switch ruleType {
+	case "", typeMatch:
+		if composite {
+			// Conditions are checked on whole object, so results can't be checked separately
+			if rule.ForEach {
+				return fmt.Errorf("ForEach can't be used with allOf, anyOf and not")
+			}
+			parsed, err := parseCondition(fmt.Sprintf("%s %s", kind.Kind, rule.Name), condition)
+			if err != nil {
+				return fmt.Errorf("Invalid condition: %s", err)
+			}
+			validator_rule.condition = parsed
+		}
+	case typeImmutable:
+		// Immutable rules only compare values, so options for checking them make no sense
+		if rule.Regexp != "" || rule.Match != "" || rule.ForEach {
+			return fmt.Errorf("Regexp, match and forEach can't be used with '%s' rules", typeImmutable)
+		}
+		validator_rule.immutable = true
+	case typeCEL:
+		// CEL expressions are evaluated on whole request, so options of JSONPath query make no sense
+		if rule.Jsonpath != "" || rule.Regexp != "" || rule.Match != "" || rule.ForEach {
+			return fmt.Errorf("JSONPath, regexp, match and forEach can't be used with '%s' rules", typeCEL)
+		}
+		if rule.Cel == "" {
+			return fmt.Errorf("CEL expression can't be empty")
+		}
+		expression, usesNamespace, err := compileCEL(rule.Cel)
+		if err != nil {
+			return fmt.Errorf("Failed to compile CEL expression: %s", err)
+		}
+		validator_rule.expression = expression
+		validator_rule.expressionNamespace = usesNamespace
+	case typeRego:
+		// Rejection messages are produced by the policy, so message of the rule is not used
+		if rule.Jsonpath != "" || rule.Regexp != "" || rule.Match != "" || rule.ForEach || rule.Message != "" {
+			return fmt.Errorf("JSONPath, regexp, match, forEach and message can't be used with '%s' rules", typeRego)
+		}
+		// Policy files are read when loading config file, so module is always set here
+		if rule.Rego == "" {
+			return fmt.Errorf("Rego policy can't be empty")
+		}
+		filename := rule.RegoFile
+		if filename == "" {
+			filename = rule.Name + ".rego"
+		}
+		policy, err := compileRego(filename, rule.Rego)
+		if err != nil {
+			return fmt.Errorf("Failed to compile Rego policy: %s", err)
+		}
+		validator_rule.policy = policy
+	case typeSchema:
+		// Violations are described by the schema, so options of JSONPath query and message are not used
+		if rule.Jsonpath != "" || rule.Regexp != "" || rule.Match != "" || rule.ForEach || rule.Message != "" {
+			return fmt.Errorf("JSONPath, regexp, match, forEach and message can't be used with '%s' rules", typeSchema)
+		}
+		// Schema is compiled when loading config file, rules can't refer to it directly
+		if rule.schema == nil {
+			return fmt.Errorf("'%s' rules can only be created from schema of the kind", typeSchema)
+		}
+		validator_rule.schema = rule.schema
+	default:
+		return fmt.Errorf("Unsupported rule type '%s', expected '%s', '%s', '%s' or '%s'", rule.Type, typeMatch, typeImmutable, typeCEL, typeRego)
+	}
+
+	// Create JSONPath query
+	if rule.Jsonpath != "" {
+		validator_rule.jsonpath = NewQuery(fmt.Sprintf("%s %s", kind.Kind, rule.Name))
+		validator_rule.jsonpath.AllowMissingKeys(true)
+		if err := validator_rule.jsonpath.Parse(rule.Jsonpath); err != nil {
+			return err
+		}
+	}
+
+	// Operations of the rule override operations of the kind
+	switch {
+	case len(rule.Operations) > 0:
+		if validator_rule.operations, err = parseOperations(rule.Operations); err != nil {
+			return err
+		}
+	// Old object is only available on UPDATE, so immutable rules have nothing to compare otherwise
+	case validator_rule.immutable:
+		validator_rule.operations = map[string]bool{string(admissionv1.Update): true}
+	case validator_rule.operations == nil:
+		validator_rule.operations = defaultOperations
+	}
+	for operation := range validator_rule.operations {
+		if validator_rule.immutable && admissionv1.Operation(operation) != admissionv1.Update {
+			return fmt.Errorf("'%s' rules can only be applied on '%s' operation, got '%s'", typeImmutable, admissionv1.Update, operation)
+		}
+	}
+
+	switch rule.Match {
+	case "", matchForbidden:
+	case matchRequired:
+		validator_rule.required = true
+	default:
+		return fmt.Errorf("Unsupported match mode '%s', expected '%s' or '%s'", rule.Match, matchForbidden, matchRequired)
+	}
+
+	switch rule.Enforcement {
+	case "":
+		validator_rule.enforcement = enforcementDeny
+	case enforcementDeny, enforcementAudit, enforcementWarn:
+		validator_rule.enforcement = rule.Enforcement
+	default:
+		return fmt.Errorf("Unsupported enforcement '%s', expected '%s', '%s' or '%s'", rule.Enforcement, enforcementDeny, enforcementAudit, enforcementWarn)
+	}
+
+	// Make sure namespace patterns are valid, so they can be matched without checking errors later
+	for _, pattern := range append(rule.Namespaces, rule.ExcludeNamespaces...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("Invalid namespace pattern '%s': %s", pattern, err)
+		}
+	}
+	validator_rule.namespaces = rule.Namespaces
+	validator_rule.excludeNamespaces = rule.ExcludeNamespaces
+
+	// Same applies to patterns of exempted users
+	for _, patterns := range [][]string{rule.ExemptUsers, rule.ExemptGroups, rule.ExemptServiceAccounts} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("Invalid exemption pattern '%s': %s", pattern, err)
+			}
+		}
+	}
+	for _, pattern := range rule.ExemptServiceAccounts {
+		if strings.Count(pattern, "/") != 1 {
+			return fmt.Errorf("Invalid service account pattern '%s', expected namespace/name format", pattern)
+		}
+	}
+	validator_rule.exemptUsers = rule.ExemptUsers
+	validator_rule.exemptGroups = rule.ExemptGroups
+	validator_rule.exemptServiceAccounts = rule.ExemptServiceAccounts
+
+	if rule.NamespaceSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector((*metav1.LabelSelector)(rule.NamespaceSelector))
+		if err != nil {
+			return fmt.Errorf("Invalid namespace selector: %s", err)
+		}
+		validator_rule.namespaceSelector = selector
+	}
+
+	if rule.ObjectSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector((*metav1.LabelSelector)(rule.ObjectSelector))
+		if err != nil {
+			return fmt.Errorf("Invalid object selector: %s", err)
+		}
+		validator_rule.objectSelector = selector
 	}
 
 	// Compile regexp
@@ -75,44 +345,733 @@ func (v *Validator) AddRule(kind string, rule ConfigRule) error {
 	return nil
 }
 
+// rulesFor returns rules defined for given kind, including rules defined with wildcard group or version
+func (v *Validator) rulesFor(kind metav1.GroupVersionKind) []ValidatorRule {
+	var rules []ValidatorRule
+
+	for _, group := range []string{kind.Group, wildcard} {
+		for _, version := range []string{kind.Version, wildcard} {
+			rules = append(rules, v.rules[metav1.GroupVersionKind{Group: group, Version: version, Kind: kind.Kind}]...)
+			// Avoid returning same rules twice if given kind is already a wildcard
+			if version == wildcard {
+				break
+			}
+		}
+		if group == wildcard {
+			break
+		}
+	}
+
+	return rules
+}
+
+// RuleCount returns number of rules defined for all kinds
+func (v *Validator) RuleCount() int {
+	count := 0
+	for _, rules := range v.rules {
+		count += len(rules)
+	}
+	return count
+}
+
+// UsesNamespaces returns true if at least one rule is scoped to namespaces using namespace labels
+// or refers to namespace object in CEL expression
+func (v *Validator) UsesNamespaces() bool {
+	for _, rules := range v.rules {
+		for _, rule := range rules {
+			if rule.namespaceSelector != nil || rule.expressionNamespace {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HasKind returns true if there is at least one rule defined for given kind
+func (v *Validator) HasKind(kind metav1.GroupVersionKind) bool {
+	return len(v.rulesFor(kind)) > 0
+}
+
+// HasOperation checks if validator has any rules for given kind, which apply to given operation
+func (v *Validator) HasOperation(kind metav1.GroupVersionKind, operation string) bool {
+	for _, rule := range v.rulesFor(kind) {
+		if rule.operations[operation] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Violation describes single violation of a rule found in validated object
+type Violation struct {
+	Rule        string // Name of violated rule
+	Message     string // Message of violated rule
+	Path        string // JSONPath query of violated rule, or JSON pointer of invalid field for schema violations
+	Value       string // Query output, which violated the rule
+	Element     *int   // Index of query result, which violated forEach rule, nil for other rules
+	Enforcement string // Enforcement mode of violated rule
+}
+
+// Violations is a list of rule violations found in validated object
+type Violations []Violation
+
+// Allowed returns true if object did not violate any rule in deny mode
+func (v Violations) Allowed() bool {
+	return len(v.Filter(enforcementDeny)) == 0
+}
+
+// Filter returns violations of rules with given enforcement mode
+func (v Violations) Filter(enforcement string) Violations {
+	var violations Violations
+	for _, violation := range v {
+		if violation.Enforcement == enforcement {
+			violations = append(violations, violation)
+		}
+	}
+	return violations
+}
+
+// Messages returns messages of all violations
+func (v Violations) Messages() []string {
+	var messages []string
+	for _, violation := range v {
+		messages = append(messages, violation.Message)
+	}
+	return messages
+}
+
+// ValidationRequest holds object for validation together with details of admission request
+type ValidationRequest struct {
+	UID             string                    // Admission request UID, used for logging
+	Kind            metav1.GroupVersionKind   // Kind of validated object
+	Name            string                    // Name of validated object
+	Namespace       string                    // Namespace of validated object
+	Operation       string                    // Operation of admission request, e.g. CREATE
+	UserInfo        authenticationv1.UserInfo // User sending the request
+	NamespaceObject *corev1.Namespace         // Namespace of validated object, nil if unknown or object is not namespaced
+	Object          interface{}               // Deserialized object to validate, for DELETE it is the deleted object
+	OldObject       interface{}               // Deserialized existing object, only set for UPDATE requests
+}
+
+// attributes returns attributes of admission request in the same format as in AdmissionReview,
+// without validated objects, so they can be passed to policy engines
+func (req *ValidationRequest) attributes() map[string]interface{} {
+	groups := []interface{}{}
+	for _, group := range req.UserInfo.Groups {
+		groups = append(groups, group)
+	}
+
+	extra := map[string]interface{}{}
+	for key, values := range req.UserInfo.Extra {
+		extraValues := []interface{}{}
+		for _, value := range values {
+			extraValues = append(extraValues, value)
+		}
+		extra[key] = extraValues
+	}
+
+	return map[string]interface{}{
+		"uid": req.UID,
+		"kind": map[string]interface{}{
+			"group":   req.Kind.Group,
+			"version": req.Kind.Version,
+			"kind":    req.Kind.Kind,
+		},
+		"name":      req.Name,
+		"namespace": req.Namespace,
+		"operation": req.Operation,
+		"userInfo": map[string]interface{}{
+			"username": req.UserInfo.Username,
+			"uid":      req.UserInfo.UID,
+			"groups":   groups,
+			"extra":    extra,
+		},
+	}
+}
+
+// messageData is passed to message templates of violated rules
+type messageData struct {
+	Name      string                    // Name of validated object
+	Namespace string                    // Namespace of validated object
+	Kind      string                    // Kind of validated object
+	Operation string                    // Operation of admission request
+	Value     string                    // Query output, which violated the rule
+	OldValue  string                    // Query output for existing object, only set for immutable rules
+	Index     int                       // Index of query result, which violated the rule, only set for forEach rules
+	UserInfo  authenticationv1.UserInfo // User sending the request
+	Object    interface{}               // Deserialized validated object, e.g. {{.Object.spec.replicas}}
+}
+
+// Name of function, which every printed value of message template is passed through
+const messageValueFunc = "messageValue"
+
+// messageValue prints values missing in validated object as empty strings rather than "<no value>"
+func messageValue(value interface{}) interface{} {
+	if value == nil {
+		return ""
+	}
+	return value
+}
+
+// parseMessage parses message template of the rule and makes sure it refers only to existing fields
+// Fields of validated object are not known in advance, so any of them is accepted
+func parseMessage(name, message string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(template.FuncMap{messageValueFunc: messageValue}).Parse(message)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse message template: %s", err)
+	}
+
+	for _, t := range tmpl.Templates() {
+		if t.Tree == nil {
+			continue
+		}
+		// Dot of templates other than the main one is not known, so their fields are not checked
+		if err := walkMessage(t.Tree.Root, t.Name() == name); err != nil {
+			return nil, fmt.Errorf("Invalid message template: %s", err)
+		}
+	}
+
+	return tmpl, nil
+}
+
+// walkMessage checks fields referred in template nodes and passes printed values through messageValue
+// dot tells, if dot of the node is messageData, which is not the case e.g. inside range
+func walkMessage(node parse.Node, dot bool) error {
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return nil
+		}
+		for _, n := range node.Nodes {
+			if err := walkMessage(n, dot); err != nil {
+				return err
+			}
+		}
+	case *parse.ActionNode:
+		if err := walkMessage(node.Pipe, dot); err != nil {
+			return err
+		}
+		// Values assigned to variables are not printed
+		if len(node.Pipe.Decl) == 0 {
+			node.Pipe.Cmds = append(node.Pipe.Cmds, &parse.CommandNode{
+				NodeType: parse.NodeCommand,
+				Pos:      node.Pos,
+				Args:     []parse.Node{parse.NewIdentifier(messageValueFunc).SetPos(node.Pos)},
+			})
+		}
+	case *parse.PipeNode:
+		if node == nil {
+			return nil
+		}
+		for _, cmd := range node.Cmds {
+			if err := walkMessage(cmd, dot); err != nil {
+				return err
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range node.Args {
+			if err := walkMessage(arg, dot); err != nil {
+				return err
+			}
+		}
+	case *parse.ChainNode:
+		return walkMessage(node.Node, dot)
+	case *parse.FieldNode:
+		if dot {
+			return checkMessageField(node.Ident)
+		}
+	case *parse.VariableNode:
+		if node.Ident[0] == "$" {
+			return checkMessageField(node.Ident[1:])
+		}
+	case *parse.IfNode:
+		return walkMessageBranch(&node.BranchNode, dot, dot)
+	case *parse.WithNode:
+		return walkMessageBranch(&node.BranchNode, dot, false)
+	case *parse.RangeNode:
+		return walkMessageBranch(&node.BranchNode, dot, false)
+	case *parse.TemplateNode:
+		return walkMessage(node.Pipe, dot)
+	}
+
+	return nil
+}
+
+// walkMessageBranch walks if, with and range nodes, where dot of the body may differ from dot of the pipeline
+func walkMessageBranch(node *parse.BranchNode, dot, bodyDot bool) error {
+	if err := walkMessage(node.Pipe, dot); err != nil {
+		return err
+	}
+	if err := walkMessage(node.List, bodyDot); err != nil {
+		return err
+	}
+	return walkMessage(node.ElseList, dot)
+}
+
+// checkMessageField checks if chain of fields exists in messageData
+// Maps, like validated object or extra user info, may have any keys
+func checkMessageField(fields []string) error {
+	typ := reflect.TypeOf(messageData{})
+	for _, field := range fields {
+		if typ.Kind() == reflect.Map || typ.Kind() == reflect.Interface {
+			return nil
+		}
+		if _, ok := reflect.PointerTo(typ).MethodByName(field); ok {
+			return nil
+		}
+		if typ.Kind() != reflect.Struct {
+			return fmt.Errorf("Can't evaluate field '%s' in type %s", field, typ)
+		}
+		f, ok := typ.FieldByName(field)
+		if !ok {
+			return fmt.Errorf("Can't evaluate field '%s' in type %s", field, typ)
+		}
+		typ = f.Type
+	}
+
+	return nil
+}
+
 // Validate takes object for validation, looks up available validators for given kind and executes them
-func (v *Validator) Validate(uid string, kind string, object interface{}) error {
-	var errors []string
+// Only violations of rules in deny mode should reject the object
+func (v *Validator) Validate(req *ValidationRequest) Violations {
+	var violations Violations
+
+	uid := req.UID
 
 	// Iterate over all rules we have defined
-	for _, rule := range v.rules[kind] {
-		buf := new(bytes.Buffer)
-		if err := rule.jsonpath.Execute(buf, object); err != nil {
-			glog.Errorf("UID=%s Rule=%s: Could not execute JSONPath rule: %v", uid, rule.name, err)
-			errors = append(errors, "Failed to validate object")
+	for _, rule := range v.rulesFor(req.Kind) {
+		// Rules are scoped to operations, e.g. required labels may only be checked on CREATE
+		if !rule.operations[req.Operation] {
+			glog.V(4).Infof("UID=%s Rule=%s: Rule not applied on operation %s", uid, rule.name, req.Operation)
 			continue
 		}
 
-		output := buf.String()
+		// Rules may be scoped to selected namespaces, e.g. to exempt kube-system
+		matches, err := rule.matchesNamespace(req)
+		if err != nil {
+			glog.Errorf("UID=%s Rule=%s: Could not match namespace: %v", uid, rule.name, err)
+			violations = append(violations, rule.failure())
+			continue
+		}
+		if !matches {
+			glog.V(4).Infof("UID=%s Rule=%s: Rule not applied in namespace %s", uid, rule.name, req.Namespace)
+			continue
+		}
 
-		// If regexp is defined and match query output, reject object
-		if rule.regexp != nil {
-			if rule.regexp.MatchString(output) {
-				glog.Infof("UID=%s Rule=%s: Query output matches regexp, rejecting", uid, rule.name)
-				errors = append(errors, rule.message)
-			}
+		if !rule.matchesObject(req) {
+			glog.V(4).Infof("UID=%s Rule=%s: Rule not applied to object with labels not matching selector", uid, rule.name)
+			continue
+		}
+
+		// Automation may need to bypass rules, so requests from exempted users are not validated
+		if rule.exempts(req.UserInfo) {
+			glog.Infof("UID=%s Rule=%s: User %s is exempted from the rule, not validating", uid, rule.name, req.UserInfo.Username)
+			ruleExemptions.WithLabelValues(req.Kind.Kind, rule.name).Inc()
 			continue
 		}
 
-		// If regexp is NOT defined but query returned some output, reject object as well
-		if output != "" {
-			glog.Infof("UID=%s Rule=%s: Query produced output and regexp not defined, rejecting", uid, rule.name)
-			errors = append(errors, rule.message)
+		ruleViolations := rule.validate(req)
+		if len(ruleViolations) == 0 {
+			continue
 		}
+
+		ruleRejections.WithLabelValues(req.Kind.Kind, rule.name, rule.enforcement).Inc()
+
+		switch rule.enforcement {
+		case enforcementAudit:
+			glog.Infof("UID=%s Rule=%s: Rule in audit mode, not rejecting", uid, rule.name)
+		case enforcementWarn:
+			glog.Infof("UID=%s Rule=%s: Rule in warn mode, not rejecting", uid, rule.name)
+		}
+
+		violations = append(violations, ruleViolations...)
+	}
+
+	if audits := violations.Filter(enforcementAudit).Messages(); len(audits) > 0 {
+		glog.Infof("UID=%s: Found %d reasons to reject in audit mode: %s", uid, len(audits), strings.Join(audits, ", "))
+	}
+
+	if warnings := violations.Filter(enforcementWarn).Messages(); len(warnings) > 0 {
+		glog.Infof("UID=%s: Found %d reasons to warn: %s", uid, len(warnings), strings.Join(warnings, ", "))
 	}
 
 	// If we found at least one error
-	if len(errors) > 0 {
-		message := fmt.Errorf(strings.Join(errors, ", "))
-		glog.Infof("UID=%s: Found %d reasons to reject: %s", uid, len(errors), message)
-		return message
+	if denials := violations.Filter(enforcementDeny).Messages(); len(denials) > 0 {
+		glog.Infof("UID=%s: Found %d reasons to reject: %s", uid, len(denials), strings.Join(denials, ", "))
+	} else {
+		glog.Infof("UID=%s: No reasons to reject, accepting", uid)
+	}
+
+	return violations
+}
+
+// violation creates Violation of the rule for given query output, with message rendered from rule template
+func (rule *ValidatorRule) violation(req *ValidationRequest, value, oldValue string) Violation {
+	return Violation{
+		Rule:        rule.name,
+		Message:     rule.renderMessage(req, newMessageData(req, value, oldValue)),
+		Path:        rule.path,
+		Value:       value,
+		Enforcement: rule.enforcement,
+	}
+}
+
+// elementViolation creates Violation of forEach rule for given element of query output
+// Index and value of the element are appended to the message, so users can tell which element violated the rule
+func (rule *ValidatorRule) elementViolation(req *ValidationRequest, value string, index int) Violation {
+	data := newMessageData(req, value, "")
+	data.Index = index
+
+	return Violation{
+		Rule:        rule.name,
+		Message:     fmt.Sprintf("%s (element %d, value '%s')", rule.renderMessage(req, data), index, value),
+		Path:        rule.path,
+		Value:       value,
+		Element:     &index,
+		Enforcement: rule.enforcement,
+	}
+}
+
+// newMessageData creates data for message template from admission request and query outputs
+func newMessageData(req *ValidationRequest, value, oldValue string) messageData {
+	return messageData{
+		Name:      req.Name,
+		Namespace: req.Namespace,
+		Kind:      req.Kind.Kind,
+		Operation: req.Operation,
+		Value:     value,
+		OldValue:  oldValue,
+		UserInfo:  req.UserInfo,
+		Object:    req.Object,
+	}
+}
+
+// renderMessage executes message template of the rule
+// If template can't be executed, template source is returned as is
+func (rule *ValidatorRule) renderMessage(req *ValidationRequest, data messageData) string {
+	buf := new(bytes.Buffer)
+	if err := rule.message.Execute(buf, data); err != nil {
+		glog.Errorf("UID=%s Rule=%s: Could not render message: %v", req.UID, rule.name, err)
+		return rule.message.Root.String()
+	}
+
+	return buf.String()
+}
+
+// failure creates Violation of the rule for case, when rule could not be executed
+func (rule *ValidatorRule) failure() Violation {
+	return Violation{
+		Rule:        rule.name,
+		Message:     "Failed to validate object",
+		Path:        rule.path,
+		Enforcement: rule.enforcement,
+	}
+}
+
+// matchesNamespace checks if rule applies to namespace of validated object
+// Objects which are not namespaced are not filtered by namespace
+func (rule *ValidatorRule) matchesNamespace(req *ValidationRequest) (bool, error) {
+	if req.Namespace == "" {
+		return true, nil
+	}
+
+	if len(rule.namespaces) > 0 && !matchesAny(rule.namespaces, req.Namespace) {
+		return false, nil
+	}
+
+	if matchesAny(rule.excludeNamespaces, req.Namespace) {
+		return false, nil
+	}
+
+	if rule.namespaceSelector == nil {
+		return true, nil
+	}
+
+	if req.NamespaceObject == nil {
+		return false, fmt.Errorf("labels of namespace %s are unknown", req.Namespace)
+	}
+
+	if rule.namespaceSelector.Matches(labels.Set(req.NamespaceObject.Labels)) {
+		return true, nil
+	}
+
+	// Namespace objects are matched using their own labels, so like for object selector, on UPDATE rule applies
+	// if either new or existing namespace matches, so labels can't be removed to bypass the rule
+	isNamespace := req.Kind.Group == "" && req.Kind.Kind == "Namespace"
+	return isNamespace && req.OldObject != nil && rule.namespaceSelector.Matches(labels.Set(objectLabels(req.OldObject))), nil
+}
+
+// matchesObject checks if labels of validated object match object selector of the rule
+// On UPDATE, rule applies if either new or existing object matches
+func (rule *ValidatorRule) matchesObject(req *ValidationRequest) bool {
+	if rule.objectSelector == nil {
+		return true
+	}
+
+	if rule.objectSelector.Matches(labels.Set(objectLabels(req.Object))) {
+		return true
+	}
+
+	return req.OldObject != nil && rule.objectSelector.Matches(labels.Set(objectLabels(req.OldObject)))
+}
+
+// objectLabels returns labels from metadata of deserialized object
+func objectLabels(object interface{}) map[string]string {
+	content, ok := object.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	return (&unstructured.Unstructured{Object: content}).GetLabels()
+}
+
+// exempts checks if given user is exempted from the rule by name, group or service account
+func (rule *ValidatorRule) exempts(user authenticationv1.UserInfo) bool {
+	if matchesAny(rule.exemptUsers, user.Username) {
+		return true
+	}
+
+	for _, group := range user.Groups {
+		if matchesAny(rule.exemptGroups, group) {
+			return true
+		}
+	}
+
+	// Service accounts authenticate as system:serviceaccount:<namespace>:<name>
+	if serviceAccount := strings.TrimPrefix(user.Username, serviceAccountPrefix); serviceAccount != user.Username {
+		return matchesAny(rule.exemptServiceAccounts, strings.Replace(serviceAccount, ":", "/", 1))
+	}
+
+	return false
+}
+
+// matchesAny checks if given name matches any of glob patterns
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validate executes rule on given object and returns found violations
+func (rule *ValidatorRule) validate(req *ValidationRequest) Violations {
+	if rule.expression != nil {
+		return rule.validateCEL(req)
+	}
+
+	if rule.policy != nil {
+		return rule.validateRego(req)
+	}
+
+	if rule.schema != nil {
+		return rule.validateSchema(req)
+	}
+
+	if rule.condition != nil {
+		return rule.validateCondition(req)
+	}
+
+	if rule.immutable {
+		return rule.validateImmutable(req)
+	}
+
+	if rule.forEach {
+		return rule.validateEach(req)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := rule.jsonpath.Execute(buf, req.Object); err != nil {
+		glog.Errorf("UID=%s Rule=%s: Could not execute JSONPath rule: %v", req.UID, rule.name, err)
+		return Violations{rule.failure()}
+	}
+
+	output := buf.String()
+
+	if reason := rule.check(output); reason != "" {
+		glog.Infof("UID=%s Rule=%s: %s, rejecting", req.UID, rule.name, reason)
+		return Violations{rule.violation(req, output, "")}
+	}
+
+	return nil
+}
+
+// validateImmutable executes JSONPath query on both old and new object and rejects object if outputs differ
+// Objects without old version, e.g. created ones, are always accepted
+func (rule *ValidatorRule) validateImmutable(req *ValidationRequest) Violations {
+	if req.OldObject == nil {
+		return nil
+	}
+
+	buf := new(bytes.Buffer)
+	if err := rule.jsonpath.Execute(buf, req.Object); err != nil {
+		glog.Errorf("UID=%s Rule=%s: Could not execute JSONPath rule: %v", req.UID, rule.name, err)
+		return Violations{rule.failure()}
+	}
+
+	oldBuf := new(bytes.Buffer)
+	if err := rule.jsonpath.Execute(oldBuf, req.OldObject); err != nil {
+		glog.Errorf("UID=%s Rule=%s: Could not execute JSONPath rule on old object: %v", req.UID, rule.name, err)
+		return Violations{rule.failure()}
+	}
+
+	output := buf.String()
+	oldOutput := oldBuf.String()
+
+	if output != oldOutput {
+		glog.Infof("UID=%s Rule=%s: Query output changed from '%s' to '%s', rejecting", req.UID, rule.name, oldOutput, output)
+		return Violations{rule.violation(req, output, oldOutput)}
+	}
+
+	return nil
+}
+
+// validateCEL evaluates CEL expression of the rule and rejects object if it returns false
+func (rule *ValidatorRule) validateCEL(req *ValidationRequest) Violations {
+	valid, err := evalCEL(rule.expression, req)
+	if err != nil {
+		glog.Errorf("UID=%s Rule=%s: Could not evaluate CEL expression: %v", req.UID, rule.name, err)
+		return Violations{rule.failure()}
+	}
+
+	if !valid {
+		glog.Infof("UID=%s Rule=%s: CEL expression returned false, rejecting", req.UID, rule.name)
+		return Violations{rule.violation(req, "", "")}
+	}
+
+	return nil
+}
+
+// validateCondition evaluates condition tree of composite rule and rejects object if the condition holds
+func (rule *ValidatorRule) validateCondition(req *ValidationRequest) Violations {
+	holds, err := rule.condition.holds(req.Object)
+	if err != nil {
+		glog.Errorf("UID=%s Rule=%s: Could not evaluate condition: %v", req.UID, rule.name, err)
+		return Violations{rule.failure()}
+	}
+
+	if holds {
+		glog.Infof("UID=%s Rule=%s: Condition holds, rejecting", req.UID, rule.name)
+		return Violations{rule.violation(req, "", "")}
 	}
 
-	glog.Infof("UID=%s: No reasons to reject, accepting", uid)
 	return nil
 }
+
+// validateRego evaluates Rego policy of the rule and returns violation for each message of its deny rule
+func (rule *ValidatorRule) validateRego(req *ValidationRequest) Violations {
+	messages, err := evalRego(rule.policy, req)
+	if err != nil {
+		glog.Errorf("UID=%s Rule=%s: Could not evaluate Rego policy: %v", req.UID, rule.name, err)
+		return Violations{rule.failure()}
+	}
+
+	var violations Violations
+	for _, message := range messages {
+		glog.Infof("UID=%s Rule=%s: Rego policy denied object with message '%s', rejecting", req.UID, rule.name, message)
+		violations = append(violations, Violation{
+			Rule:        rule.name,
+			Message:     message,
+			Enforcement: rule.enforcement,
+		})
+	}
+
+	return violations
+}
+
+// validateSchema validates object against schema of the kind and returns violation for each schema error
+// Violations point to invalid fields using JSON pointer instead of JSONPath query
+func (rule *ValidatorRule) validateSchema(req *ValidationRequest) Violations {
+	errors, err := validateSchema(rule.schema, req.Object)
+	if err != nil {
+		glog.Errorf("UID=%s Rule=%s: Could not validate object against schema: %v", req.UID, rule.name, err)
+		return Violations{rule.failure()}
+	}
+
+	var violations Violations
+	for _, schemaError := range errors {
+		message := fmt.Sprintf("at '%s': %s", schemaError.Pointer, schemaError.Message)
+		glog.Infof("UID=%s Rule=%s: Object doesn't match schema %s, rejecting", req.UID, rule.name, message)
+		violations = append(violations, Violation{
+			Rule:        rule.name,
+			Message:     message,
+			Path:        schemaError.Pointer,
+			Enforcement: rule.enforcement,
+		})
+	}
+
+	return violations
+}
+
+// validateEach executes JSONPath query and checks each returned result separately
+// Returned violations contain index and value of rejected results
+func (rule *ValidatorRule) validateEach(req *ValidationRequest) Violations {
+	var violations Violations
+
+	results, err := rule.jsonpath.FindResults(req.Object)
+	if err != nil {
+		glog.Errorf("UID=%s Rule=%s: Could not execute JSONPath rule: %v", req.UID, rule.name, err)
+		return Violations{rule.failure()}
+	}
+
+	index := 0
+	for _, result := range results {
+		for _, value := range result {
+			buf := new(bytes.Buffer)
+			if err := rule.jsonpath.PrintResults(buf, []reflect.Value{value}); err != nil {
+				glog.Errorf("UID=%s Rule=%s: Could not print JSONPath result %d: %v", req.UID, rule.name, index, err)
+				violations = append(violations, rule.failure())
+				index++
+				continue
+			}
+
+			output := buf.String()
+
+			if reason := rule.check(output); reason != "" {
+				glog.Infof("UID=%s Rule=%s: %s for element %d, rejecting", req.UID, rule.name, reason, index)
+				violations = append(violations, rule.elementViolation(req, output, index))
+			}
+
+			index++
+		}
+	}
+
+	return violations
+}
+
+// check returns reason for rejecting given query output or empty string, if output is accepted
+func (rule *ValidatorRule) check(output string) string {
+	return check(rule.regexp, rule.required, output)
+}
+
+// check returns reason for rejecting given query output using optional regexp or empty string, if output is accepted
+// Conditions of composite rules check query outputs the same way as match rules
+func check(pattern *regexp.Regexp, required bool, output string) string {
+	// If regexp is defined and match query output, reject object
+	// For required rules, reject object if regexp does NOT match query output
+	if pattern != nil {
+		matches := pattern.MatchString(output)
+		if matches && !required {
+			return "Query output matches regexp"
+		}
+		if !matches && required {
+			return "Query output does not match required regexp"
+		}
+		return ""
+	}
+
+	// If regexp is NOT defined but query returned some output, reject object as well
+	// For required rules, reject object if query returned no output
+	if output != "" && !required {
+		return "Query produced output and regexp not defined"
+	}
+	if output == "" && required {
+		return "Query produced no output for required rule"
+	}
+
+	return ""
+}
@@ -2,7 +2,13 @@ package main
 
 import (
 	"encoding/json"
+	"strings"
 	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func TestAddTypeNoJsonPath(t *testing.T) {
@@ -10,7 +16,7 @@ func TestAddTypeNoJsonPath(t *testing.T) {
 		Name: "TestAddTypeNoJsonPath",
 	}
 	validator := NewValidator()
-	if err := validator.AddRule("Foo", rule); err == nil {
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err == nil {
 		t.Errorf("Validator should reject rules without JSONPath defined")
 	}
 }
@@ -21,7 +27,7 @@ func TestAddRuleNoKind(t *testing.T) {
 		Jsonpath: "{}",
 	}
 	validator := NewValidator()
-	if err := validator.AddRule("", rule); err == nil {
+	if err := validator.AddRule(metav1.GroupVersionKind{}, nil, rule); err == nil {
 		t.Errorf("Validator should reject rules without Type defined")
 	}
 }
@@ -31,15 +37,15 @@ func TestAddRuleNoName(t *testing.T) {
 		Jsonpath: "{}",
 	}
 	validator := NewValidator()
-	if err := validator.AddRule("Foo", rule); err == nil {
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err == nil {
 		t.Errorf("Validator should reject rules without Name defined")
 	}
 }
 
 func TestValidateEmpty(t *testing.T) {
 	validator := NewValidator()
-	if err := validator.Validate("Empty", "Foo", "{}"); err != nil {
-		t.Errorf("Empty validator should never return error: %s", err)
+	if result := validator.Validate(&ValidationRequest{UID: "Empty", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Operation: "CREATE", Object: "{}"}); !result.Allowed() {
+		t.Errorf("Empty validator should never return error: %s", result.Filter(enforcementDeny).Messages())
 	}
 }
 
@@ -49,7 +55,7 @@ func TestAddRuleNoRegex(t *testing.T) {
 		Jsonpath: "{}",
 	}
 	validator := NewValidator()
-	if err := validator.AddRule("Foo", rule); err != nil {
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err != nil {
 		t.Errorf("Validator should accept rules without Regexp defined: %s", err)
 	}
 }
@@ -60,7 +66,7 @@ func TestAddRuleMalformedJsonpath(t *testing.T) {
 		Jsonpath: "{",
 	}
 	validator := NewValidator()
-	if err := validator.AddRule("Foo", rule); err == nil {
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err == nil {
 		t.Errorf("Malformed JSONPath shouldn't create validator rule")
 	}
 }
@@ -72,7 +78,7 @@ func TestAddRuleMalformedRegexp(t *testing.T) {
 		Regexp:   "[",
 	}
 	validator := NewValidator()
-	if err := validator.AddRule("Foo", rule); err == nil {
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err == nil {
 		t.Errorf("Malformed regexp shouldn't create validator rule")
 	}
 }
@@ -84,10 +90,10 @@ func TestAddRule(t *testing.T) {
 		Regexp:   ".*",
 	}
 	validator := NewValidator()
-	if err := validator.AddRule("Foo", rule); err != nil {
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err != nil {
 		t.Errorf("Validator shouldn't fail adding rule: %s", err)
 	}
-	if i := len(validator.rules["Foo"]); i != 1 {
+	if i := len(validator.rules[metav1.GroupVersionKind{Kind: "Foo"}]); i != 1 {
 		t.Errorf("Validator should accept valid rules. Created rules: %d", i)
 	}
 }
@@ -99,11 +105,11 @@ func TestValidateEmptyJsonpath(t *testing.T) {
 		Regexp:   ".*",
 	}
 	validator := NewValidator()
-	if err := validator.AddRule("Foo", rule); err == nil {
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err == nil {
 		t.Errorf("Adding rule should fail")
 	}
-	if err := validator.Validate("TestValidateEmptyJsonpath", "Foo", `{"foo": 0}`); err != nil {
-		t.Errorf("Validation of empty rule should pass: %s", err)
+	if result := validator.Validate(&ValidationRequest{UID: "TestValidateEmptyJsonpath", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Operation: "CREATE", Object: `{"foo": 0}`}); !result.Allowed() {
+		t.Errorf("Validation of empty rule should pass: %s", result.Filter(enforcementDeny).Messages())
 	}
 }
 
@@ -113,7 +119,7 @@ func TestValidateNoRegexp(t *testing.T) {
 		Jsonpath: "{.apiVersion}",
 	}
 	validator := NewValidator()
-	if err := validator.AddRule("Foo", rule); err != nil {
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err != nil {
 		t.Errorf("Validator shouldn't fail adding rule: %s", err)
 	}
 	var object map[string]string
@@ -121,7 +127,7 @@ func TestValidateNoRegexp(t *testing.T) {
 		t.Errorf("Deserializing should not fail")
 	}
 
-	if err := validator.Validate("TestValidateNoRegexp", "Foo", object); err == nil {
+	if result := validator.Validate(&ValidationRequest{UID: "TestValidateNoRegexp", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Operation: "CREATE", Object: object}); result.Allowed() {
 		t.Errorf("Validating object wihtout regexp should fail")
 	}
 }
@@ -133,7 +139,7 @@ func TestValidateRejectRegexpMatch(t *testing.T) {
 		Regexp:   "foo",
 	}
 	validator := NewValidator()
-	if err := validator.AddRule("Foo", rule); err != nil {
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err != nil {
 		t.Errorf("Validator shouldn't fail adding rule: %s", err)
 	}
 	var object map[string]string
@@ -141,7 +147,7 @@ func TestValidateRejectRegexpMatch(t *testing.T) {
 		t.Errorf("Deserializing should not fail")
 	}
 
-	if err := validator.Validate("TestValidateRejectRegexpMatch", "Foo", object); err == nil {
+	if result := validator.Validate(&ValidationRequest{UID: "TestValidateRejectRegexpMatch", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Operation: "CREATE", Object: object}); result.Allowed() {
 		t.Errorf("Validating object matching regexp should fail")
 	}
 }
@@ -153,7 +159,7 @@ func TestValidateRejectMultipleValues(t *testing.T) {
 		Regexp:   "foo v1",
 	}
 	validator := NewValidator()
-	if err := validator.AddRule("Foo", rule); err != nil {
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err != nil {
 		t.Errorf("Validator shouldn't fail adding rule: %s", err)
 	}
 	var object map[string]interface{}
@@ -161,7 +167,7 @@ func TestValidateRejectMultipleValues(t *testing.T) {
 		t.Errorf("Deserializing should not fail")
 	}
 
-	if err := validator.Validate("TestValidateRejectMultipleValues", "Foo", object); err == nil {
+	if result := validator.Validate(&ValidationRequest{UID: "TestValidateRejectMultipleValues", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Operation: "CREATE", Object: object}); result.Allowed() {
 		t.Errorf("Validating object matching multiple values with regexp should fail")
 	}
 }
@@ -178,10 +184,10 @@ func TestValidateRejectMultipleRules(t *testing.T) {
 		Regexp:   "v1",
 	}
 	validator := NewValidator()
-	if err := validator.AddRule("Foo", rule1); err != nil {
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule1); err != nil {
 		t.Errorf("Validator shouldn't fail adding rule1")
 	}
-	if err := validator.AddRule("Foo", rule2); err != nil {
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule2); err != nil {
 		t.Errorf("Validator shouldn't fail adding rule2")
 	}
 	var object map[string]interface{}
@@ -189,7 +195,7 @@ func TestValidateRejectMultipleRules(t *testing.T) {
 		t.Errorf("Deserializing should not fail")
 	}
 
-	if err := validator.Validate("TestValidateRejectMultipleRules", "Foo", object); err == nil {
+	if result := validator.Validate(&ValidationRequest{UID: "TestValidateRejectMultipleRules", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Operation: "CREATE", Object: object}); result.Allowed() {
 		t.Errorf("Validating object with multiple rules should fail")
 	}
 }
@@ -201,7 +207,7 @@ func TestValidateRejectUnwantedLabel(t *testing.T) {
 		Regexp:   ".*",
 	}
 	validator := NewValidator()
-	if err := validator.AddRule("Foo", rule); err != nil {
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err != nil {
 		t.Errorf("Validator shouldn't fail adding rule: %s", err)
 	}
 	var object map[string]interface{}
@@ -209,7 +215,7 @@ func TestValidateRejectUnwantedLabel(t *testing.T) {
 		t.Errorf("Deserializing should not fail")
 	}
 
-	if err := validator.Validate("TestValidateRejectUnwantedLabel", "Foo", object); err == nil {
+	if result := validator.Validate(&ValidationRequest{UID: "TestValidateRejectUnwantedLabel", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Operation: "CREATE", Object: object}); result.Allowed() {
 		t.Errorf("Validating object for unwanted label should fail")
 	}
 }
@@ -221,7 +227,7 @@ func TestValidateRejectMissingLabel(t *testing.T) {
 		Regexp:   "^$",
 	}
 	validator := NewValidator()
-	if err := validator.AddRule("Foo", rule); err != nil {
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err != nil {
 		t.Errorf("Validator shouldn't fail adding rule: %s", err)
 	}
 	var object map[string]interface{}
@@ -229,7 +235,7 @@ func TestValidateRejectMissingLabel(t *testing.T) {
 		t.Errorf("Deserializing should not fail")
 	}
 
-	if err := validator.Validate("TestValidateRejectMissingLabel", "Foo", object); err == nil {
+	if result := validator.Validate(&ValidationRequest{UID: "TestValidateRejectMissingLabel", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Operation: "CREATE", Object: object}); result.Allowed() {
 		t.Errorf("Validating object with missing required label should fail")
 	}
 }
@@ -241,7 +247,7 @@ func TestValidateAcceptRequiredLabel(t *testing.T) {
 		Regexp:   "^$",
 	}
 	validator := NewValidator()
-	if err := validator.AddRule("Foo", rule); err != nil {
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err != nil {
 		t.Errorf("Validator shouldn't fail adding rule: %s", err)
 	}
 	var object map[string]interface{}
@@ -249,7 +255,7 @@ func TestValidateAcceptRequiredLabel(t *testing.T) {
 		t.Errorf("Deserializing should not fail")
 	}
 
-	if err := validator.Validate("TestValidateAcceptRequiredLabel", "Foo", object); err != nil {
+	if result := validator.Validate(&ValidationRequest{UID: "TestValidateAcceptRequiredLabel", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Operation: "CREATE", Object: object}); !result.Allowed() {
 		t.Errorf("Validating object with present required label should pass")
 	}
 }
@@ -262,7 +268,7 @@ func TestValidateShouldReturnMessage(t *testing.T) {
 		Message:  "Error message",
 	}
 	validator := NewValidator()
-	if err := validator.AddRule("Foo", rule); err != nil {
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err != nil {
 		t.Errorf("Validator shouldn't fail adding rule: %s", err)
 	}
 	var object map[string]interface{}
@@ -270,8 +276,8 @@ func TestValidateShouldReturnMessage(t *testing.T) {
 		t.Errorf("Deserializing should not fail")
 	}
 
-	if err := validator.Validate("TestValidateShouldReturnMessage", "Foo", object); err.Error() != "Error message" {
-		t.Errorf("Rejected object should return defined error message. Expected: 'Error message', got: '%s'", err)
+	if result := validator.Validate(&ValidationRequest{UID: "TestValidateShouldReturnMessage", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Operation: "CREATE", Object: object}); strings.Join(result.Filter(enforcementDeny).Messages(), ", ") != "Error message" {
+		t.Errorf("Rejected object should return defined error message. Expected: 'Error message', got: '%s'", result.Filter(enforcementDeny).Messages())
 	}
 }
 
@@ -289,10 +295,10 @@ func TestValidateShouldReturnMessagesJoined(t *testing.T) {
 		Message:  "Label bar missing",
 	}
 	validator := NewValidator()
-	if err := validator.AddRule("Foo", rule1); err != nil {
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule1); err != nil {
 		t.Errorf("Validator shouldn't fail adding rule1")
 	}
-	if err := validator.AddRule("Foo", rule2); err != nil {
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule2); err != nil {
 		t.Errorf("Validator shouldn't fail adding rule2")
 	}
 	var object map[string]interface{}
@@ -300,7 +306,1176 @@ func TestValidateShouldReturnMessagesJoined(t *testing.T) {
 		t.Errorf("Deserializing should not fail")
 	}
 
-	if err := validator.Validate("TestValidateShouldReturnMessagesJoined", "Foo", object); err.Error() != "Label foo missing, Label bar missing" {
-		t.Errorf("Rejected object should return defined error messages. Expected: 'Label foo missing, Label bar missing', got: '%s'", err)
+	if result := validator.Validate(&ValidationRequest{UID: "TestValidateShouldReturnMessagesJoined", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Operation: "CREATE", Object: object}); strings.Join(result.Filter(enforcementDeny).Messages(), ", ") != "Label foo missing, Label bar missing" {
+		t.Errorf("Rejected object should return defined error messages. Expected: 'Label foo missing, Label bar missing', got: '%s'", result.Filter(enforcementDeny).Messages())
+	}
+}
+
+func TestValidateWildcardGroupVersion(t *testing.T) {
+	rule := ConfigRule{
+		Name:     "TestValidateWildcardGroupVersion",
+		Jsonpath: "{.metadata.name}",
+		Regexp:   "foo",
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Group: "*", Version: "*", Kind: "Foo"}, nil, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule: %s", err)
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"metadata":{"name":"foo"}}`), &object); err != nil {
+		t.Errorf("Deserializing should not fail")
+	}
+
+	if result := validator.Validate(&ValidationRequest{UID: "TestValidateWildcardGroupVersion", Kind: metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Foo"}, Operation: "CREATE", Object: object}); result.Allowed() {
+		t.Errorf("Rule with wildcard group and version should match any group and version")
+	}
+}
+
+func TestValidateDifferentGroup(t *testing.T) {
+	rule := ConfigRule{
+		Name:     "TestValidateDifferentGroup",
+		Jsonpath: "{.metadata.name}",
+		Regexp:   "foo",
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Group: "apps", Version: "*", Kind: "Foo"}, nil, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule: %s", err)
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"metadata":{"name":"foo"}}`), &object); err != nil {
+		t.Errorf("Deserializing should not fail")
+	}
+
+	if validator.HasKind(metav1.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Foo"}) {
+		t.Errorf("Kind from different group should not be supported")
+	}
+
+	if result := validator.Validate(&ValidationRequest{UID: "TestValidateDifferentGroup", Kind: metav1.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Foo"}, Operation: "CREATE", Object: object}); !result.Allowed() {
+		t.Errorf("Rule for different group should not be applied: %s", result.Filter(enforcementDeny).Messages())
+	}
+
+	if result := validator.Validate(&ValidationRequest{UID: "TestValidateDifferentGroup", Kind: metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Foo"}, Operation: "CREATE", Object: object}); result.Allowed() {
+		t.Errorf("Rule for matching group should be applied")
+	}
+}
+
+func TestAddRuleUnsupportedMatch(t *testing.T) {
+	rule := ConfigRule{
+		Name:     "TestAddRuleUnsupportedMatch",
+		Jsonpath: "{}",
+		Match:    "foo",
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err == nil {
+		t.Errorf("Rule with unsupported match mode shouldn't be added")
+	}
+}
+
+func TestValidateRequiredRegexpMismatch(t *testing.T) {
+	rule := ConfigRule{
+		Name:     "TestValidateRequiredRegexpMismatch",
+		Jsonpath: "{.spec.image}",
+		Regexp:   "^registry\\.corp/",
+		Match:    "required",
+		Message:  "Image must come from registry.corp",
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule: %s", err)
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"spec":{"image":"docker.io/nginx"}}`), &object); err != nil {
+		t.Errorf("Deserializing should not fail")
+	}
+
+	if result := validator.Validate(&ValidationRequest{UID: "TestValidateRequiredRegexpMismatch", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Operation: "CREATE", Object: object}); strings.Join(result.Filter(enforcementDeny).Messages(), ", ") != "Image must come from registry.corp" {
+		t.Errorf("Validating object not matching required regexp should fail with rule message, got: '%s'", result.Filter(enforcementDeny).Messages())
+	}
+}
+
+func TestValidateRequiredRegexpMatch(t *testing.T) {
+	rule := ConfigRule{
+		Name:     "TestValidateRequiredRegexpMatch",
+		Jsonpath: "{.spec.image}",
+		Regexp:   "^registry\\.corp/",
+		Match:    "required",
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule: %s", err)
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"spec":{"image":"registry.corp/nginx"}}`), &object); err != nil {
+		t.Errorf("Deserializing should not fail")
+	}
+
+	if result := validator.Validate(&ValidationRequest{UID: "TestValidateRequiredRegexpMatch", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Operation: "CREATE", Object: object}); !result.Allowed() {
+		t.Errorf("Validating object matching required regexp should pass: %s", result.Filter(enforcementDeny).Messages())
+	}
+}
+
+func TestValidateRequiredNoRegexp(t *testing.T) {
+	rule := ConfigRule{
+		Name:     "TestValidateRequiredNoRegexp",
+		Jsonpath: "{.metadata.labels.foo}",
+		Match:    "required",
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule: %s", err)
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"metadata":{"labels":{"bar":"baz"}}}`), &object); err != nil {
+		t.Errorf("Deserializing should not fail")
+	}
+
+	if result := validator.Validate(&ValidationRequest{UID: "TestValidateRequiredNoRegexp", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Operation: "CREATE", Object: object}); result.Allowed() {
+		t.Errorf("Validating object without output for required rule should fail")
+	}
+}
+
+func TestValidateForEachRejectElement(t *testing.T) {
+	rule := ConfigRule{
+		Name:     "TestValidateForEachRejectElement",
+		Jsonpath: "{.spec.containers[*].image}",
+		Regexp:   "^registry\\.corp/",
+		Match:    "required",
+		ForEach:  true,
+		Message:  "Container {{.Index}} must use image from registry.corp",
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule: %s", err)
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"spec":{"containers":[{"image":"registry.corp/foo"},{"image":"docker.io/bar"}]}}`), &object); err != nil {
+		t.Errorf("Deserializing should not fail")
+	}
+
+	violations := validator.Validate(&ValidationRequest{UID: "TestValidateForEachRejectElement", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Operation: "CREATE", Object: object}).Filter(enforcementDeny)
+	if len(violations) != 1 {
+		t.Fatalf("Validating object with one invalid element should fail, got: %+v", violations)
+	}
+	if violation := violations[0]; violation.Message != "Container 1 must use image from registry.corp (element 1, value 'docker.io/bar')" || violation.Value != "docker.io/bar" || violation.Element == nil || *violation.Element != 1 {
+		t.Errorf("Violation should contain rendered message, index and value of invalid element, got: %+v", violation)
+	}
+}
+
+func TestValidateForEachAcceptAllElements(t *testing.T) {
+	rule := ConfigRule{
+		Name:     "TestValidateForEachAcceptAllElements",
+		Jsonpath: "{.spec.containers[*].image}",
+		Regexp:   "^registry\\.corp/",
+		Match:    "required",
+		ForEach:  true,
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule: %s", err)
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"spec":{"containers":[{"image":"registry.corp/foo"},{"image":"registry.corp/bar"}]}}`), &object); err != nil {
+		t.Errorf("Deserializing should not fail")
+	}
+
+	if result := validator.Validate(&ValidationRequest{UID: "TestValidateForEachAcceptAllElements", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Operation: "CREATE", Object: object}); !result.Allowed() {
+		t.Errorf("Validating object with all valid elements should pass: %s", result.Filter(enforcementDeny).Messages())
+	}
+}
+
+func TestValidateRuleRejectionsMetric(t *testing.T) {
+	rule := ConfigRule{
+		Name:     "TestValidateRuleRejectionsMetric",
+		Jsonpath: "{.metadata.name}",
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule: %s", err)
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"metadata":{"name":"foo"}}`), &object); err != nil {
+		t.Errorf("Deserializing should not fail")
+	}
+
+	counter := ruleRejections.WithLabelValues("Foo", "TestValidateRuleRejectionsMetric", "deny")
+	before := testutil.ToFloat64(counter)
+
+	if result := validator.Validate(&ValidationRequest{UID: "TestValidateRuleRejectionsMetric", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Operation: "CREATE", Object: object}); result.Allowed() {
+		t.Errorf("Validating object should fail")
+	}
+
+	if after := testutil.ToFloat64(counter); after != before+1 {
+		t.Errorf("Rule rejection should be counted. Expected %v, got %v", before+1, after)
+	}
+}
+
+func TestAddRuleUnsupportedEnforcement(t *testing.T) {
+	rule := ConfigRule{
+		Name:        "TestAddRuleUnsupportedEnforcement",
+		Jsonpath:    "{}",
+		Enforcement: "foo",
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err == nil {
+		t.Errorf("Rule with unsupported enforcement shouldn't be added")
+	}
+}
+
+func TestValidateAuditEnforcement(t *testing.T) {
+	rule := ConfigRule{
+		Name:        "TestValidateAuditEnforcement",
+		Jsonpath:    "{.metadata.labels.foo}",
+		Regexp:      "^$",
+		Enforcement: "audit",
+		Message:     "Label foo missing",
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule: %s", err)
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"metadata":{"labels":{"baz":"bar"}}}`), &object); err != nil {
+		t.Errorf("Deserializing should not fail")
+	}
+
+	result := validator.Validate(&ValidationRequest{UID: "TestValidateAuditEnforcement", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Operation: "CREATE", Object: object})
+	if !result.Allowed() {
+		t.Errorf("Violating rule in audit mode should not reject object: %s", result.Filter(enforcementDeny).Messages())
+	}
+
+	if audits := result.Filter(enforcementAudit).Messages(); len(audits) != 1 || audits[0] != "Label foo missing" {
+		t.Errorf("Violation of rule in audit mode should be returned, got: %v", audits)
+	}
+}
+
+func TestValidateWarnEnforcement(t *testing.T) {
+	rule := ConfigRule{
+		Name:        "TestValidateWarnEnforcement",
+		Jsonpath:    "{.metadata.labels.foo}",
+		Regexp:      "^$",
+		Enforcement: "warn",
+		Message:     "Label foo missing",
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule: %s", err)
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"metadata":{"labels":{"baz":"bar"}}}`), &object); err != nil {
+		t.Errorf("Deserializing should not fail")
+	}
+
+	result := validator.Validate(&ValidationRequest{UID: "TestValidateWarnEnforcement", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Operation: "CREATE", Object: object})
+	if !result.Allowed() {
+		t.Errorf("Violating rule in warn mode should not reject object: %s", result.Filter(enforcementDeny).Messages())
+	}
+
+	if warnings := result.Filter(enforcementWarn).Messages(); len(warnings) != 1 || warnings[0] != "Label foo missing" {
+		t.Errorf("Violation of rule in warn mode should be returned as warning, got: %v", warnings)
+	}
+}
+
+func TestValidateViolationDetails(t *testing.T) {
+	rule := ConfigRule{
+		Name:     "TestValidateViolationDetails",
+		Jsonpath: "{.metadata.labels.foo}",
+		Regexp:   "bar",
+		Message:  "Label foo can't be 100% bar",
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule: %s", err)
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"metadata":{"labels":{"foo":"bar"}}}`), &object); err != nil {
+		t.Errorf("Deserializing should not fail")
+	}
+
+	expected := Violation{
+		Rule:        "TestValidateViolationDetails",
+		Message:     "Label foo can't be 100% bar",
+		Path:        "{.metadata.labels.foo}",
+		Value:       "bar",
+		Enforcement: "deny",
+	}
+
+	violations := validator.Validate(&ValidationRequest{UID: "TestValidateViolationDetails", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Operation: "CREATE", Object: object})
+	if len(violations) != 1 || violations[0] != expected {
+		t.Errorf("Expected violation %+v, got: %+v", expected, violations)
+	}
+}
+
+func TestAddRuleMalformedMessageTemplate(t *testing.T) {
+	rule := ConfigRule{
+		Name:     "TestAddRuleMalformedMessageTemplate",
+		Jsonpath: "{}",
+		Message:  "{{.Name",
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err == nil {
+		t.Errorf("Rule with malformed message template shouldn't be added")
+	}
+}
+
+func TestAddRuleMessageTemplateUnknownField(t *testing.T) {
+	rule := ConfigRule{
+		Name:     "TestAddRuleMessageTemplateUnknownField",
+		Jsonpath: "{}",
+		Message:  "{{.Foo}}",
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err == nil {
+		t.Errorf("Rule with message template referring to unknown field shouldn't be added")
+	}
+}
+
+func TestValidateMessageTemplateIndex(t *testing.T) {
+	rule := ConfigRule{
+		Name:     "TestValidateMessageTemplateIndex",
+		Jsonpath: "{.metadata.annotations.foo}",
+		Regexp:   "unconfined",
+		Message:  "Annotation foo can't be set by group {{index .UserInfo.Groups 0}}",
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule indexing user groups: %s", err)
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"metadata":{"annotations":{"foo":"unconfined"}}}`), &object); err != nil {
+		t.Errorf("Deserializing should not fail")
+	}
+
+	req := &ValidationRequest{
+		UID:       "TestValidateMessageTemplateIndex",
+		Kind:      metav1.GroupVersionKind{Kind: "Foo"},
+		Operation: "CREATE",
+		UserInfo:  authenticationv1.UserInfo{Username: "alice", Groups: []string{"developers"}},
+		Object:    object,
+	}
+
+	expected := "Annotation foo can't be set by group developers"
+	if messages := validator.Validate(req).Messages(); len(messages) != 1 || messages[0] != expected {
+		t.Errorf("Expected rendered message '%s', got: %v", expected, messages)
+	}
+}
+
+func TestValidateMessageTemplate(t *testing.T) {
+	rule := ConfigRule{
+		Name:     "TestValidateMessageTemplate",
+		Jsonpath: "{.metadata.annotations.foo}",
+		Regexp:   "unconfined",
+		Message:  "{{.Kind}} {{.Namespace}}/{{.Name}} created by {{.UserInfo.Username}} has annotation foo set to '{{.Value}}'",
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule: %s", err)
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"metadata":{"annotations":{"foo":"unconfined"}}}`), &object); err != nil {
+		t.Errorf("Deserializing should not fail")
+	}
+
+	req := &ValidationRequest{
+		UID:       "TestValidateMessageTemplate",
+		Kind:      metav1.GroupVersionKind{Kind: "Foo"},
+		Name:      "bar",
+		Namespace: "baz",
+		Operation: "CREATE",
+		UserInfo:  authenticationv1.UserInfo{Username: "alice"},
+		Object:    object,
+	}
+
+	expected := "Foo baz/bar created by alice has annotation foo set to 'unconfined'"
+	if messages := validator.Validate(req).Messages(); len(messages) != 1 || messages[0] != expected {
+		t.Errorf("Expected rendered message '%s', got: %v", expected, messages)
+	}
+}
+
+func TestValidateMessageTemplateObject(t *testing.T) {
+	rule := ConfigRule{
+		Name:     "TestValidateMessageTemplateObject",
+		Jsonpath: "{.spec.replicas}",
+		Regexp:   "^[0-9]{2,}$",
+		Message:  "Team {{.Object.metadata.labels.team}} can't run {{.Object.spec.replicas}} replicas",
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule referring to object fields: %s", err)
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"metadata":{"labels":{"team":"foo"}},"spec":{"replicas":10}}`), &object); err != nil {
+		t.Errorf("Deserializing should not fail")
+	}
+
+	expected := "Team foo can't run 10 replicas"
+	if messages := validator.Validate(&ValidationRequest{UID: "TestValidateMessageTemplateObject", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Operation: "CREATE", Object: object}).Messages(); len(messages) != 1 || messages[0] != expected {
+		t.Errorf("Expected rendered message '%s', got: %v", expected, messages)
+	}
+}
+
+func TestValidateMessageTemplateObjectFields(t *testing.T) {
+	cases := []struct {
+		name     string
+		message  string
+		object   string
+		expected string
+	}{
+		{
+			name:     "comparison",
+			message:  "{{if gt .Object.spec.replicas 3.0}}Too many{{else}}Enough{{end}} replicas",
+			object:   `{"spec":{"replicas":10,"containers":[{"image":"nginx"}]}}`,
+			expected: "Too many replicas",
+		},
+		{
+			name:     "index",
+			message:  "Image {{(index .Object.spec.containers 0).image}} is not allowed",
+			object:   `{"spec":{"replicas":10,"containers":[{"image":"nginx"}]}}`,
+			expected: "Image nginx is not allowed",
+		},
+		{
+			name:     "range",
+			message:  "Images:{{range .Object.spec.containers}} {{.image}}{{end}}",
+			object:   `{"spec":{"replicas":10,"containers":[{"image":"nginx"},{"image":"redis"}]}}`,
+			expected: "Images: nginx redis",
+		},
+		{
+			name:     "missing labels",
+			message:  "Team '{{.Object.metadata.labels.team}}' can't run {{.Object.spec.replicas}} replicas",
+			object:   `{"metadata":{"name":"foo"},"spec":{"replicas":10}}`,
+			expected: "Team '' can't run 10 replicas",
+		},
+		{
+			name:     "missing key",
+			message:  "Team '{{.Object.metadata.labels.team}}'",
+			object:   `{"metadata":{"labels":{"app":"foo"}},"spec":{"replicas":10}}`,
+			expected: "Team ''",
+		},
+	}
+
+	for _, c := range cases {
+		rule := ConfigRule{
+			Name:     "TestValidateMessageTemplateObjectFields",
+			Jsonpath: "{.spec.replicas}",
+			Regexp:   "^[0-9]{2,}$",
+			Message:  c.message,
+		}
+		validator := NewValidator()
+		if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err != nil {
+			t.Errorf("%s: Validator shouldn't fail adding rule: %s", c.name, err)
+			continue
+		}
+		var object map[string]interface{}
+		if err := json.Unmarshal([]byte(c.object), &object); err != nil {
+			t.Errorf("%s: Deserializing should not fail", c.name)
+		}
+
+		req := &ValidationRequest{UID: "TestValidateMessageTemplateObjectFields", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Operation: "CREATE", Object: object}
+		if messages := validator.Validate(req).Messages(); len(messages) != 1 || messages[0] != c.expected {
+			t.Errorf("%s: Expected rendered message '%s', got: %v", c.name, c.expected, messages)
+		}
+	}
+}
+
+func TestAddRuleMessageTemplateUnknownNestedField(t *testing.T) {
+	for _, message := range []string{"{{.UserInfo.Foo}}", "{{.Value.Foo}}", "{{range .UserInfo.Groups}}{{$.Foo}}{{end}}"} {
+		rule := ConfigRule{
+			Name:     "TestAddRuleMessageTemplateUnknownNestedField",
+			Jsonpath: "{}",
+			Message:  message,
+		}
+		validator := NewValidator()
+		if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err == nil {
+			t.Errorf("Rule with message template '%s' referring to unknown field shouldn't be added", message)
+		}
+	}
+}
+
+func TestAddRuleUnsupportedType(t *testing.T) {
+	rule := ConfigRule{
+		Name:     "TestAddRuleUnsupportedType",
+		Type:     "foo",
+		Jsonpath: "{}",
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err == nil {
+		t.Errorf("Rule with unsupported type shouldn't be added")
+	}
+}
+
+func TestAddRuleImmutableWithRegexp(t *testing.T) {
+	rule := ConfigRule{
+		Name:     "TestAddRuleImmutableWithRegexp",
+		Type:     "immutable",
+		Jsonpath: "{.metadata.labels.team}",
+		Regexp:   "foo",
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err == nil {
+		t.Errorf("Immutable rule with regexp shouldn't be added")
+	}
+}
+
+func TestValidateImmutable(t *testing.T) {
+	rule := ConfigRule{
+		Name:     "TestValidateImmutable",
+		Type:     "immutable",
+		Jsonpath: "{.metadata.labels.team}",
+		Message:  "Label team can't be changed from '{{.OldValue}}' to '{{.Value}}'",
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule: %s", err)
+	}
+	var object, oldObject, sameObject map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"metadata":{"labels":{"team":"foo"}}}`), &object); err != nil {
+		t.Errorf("Deserializing should not fail")
+	}
+	if err := json.Unmarshal([]byte(`{"metadata":{"labels":{"team":"bar"}}}`), &oldObject); err != nil {
+		t.Errorf("Deserializing should not fail")
+	}
+	if err := json.Unmarshal([]byte(`{"metadata":{"labels":{"team":"foo","app":"baz"}}}`), &sameObject); err != nil {
+		t.Errorf("Deserializing should not fail")
+	}
+
+	if result := validator.Validate(&ValidationRequest{UID: "TestValidateImmutable", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Operation: "CREATE", Object: object}); !result.Allowed() {
+		t.Errorf("Immutable rule should accept objects without old version: %s", result.Messages())
+	}
+
+	if result := validator.Validate(&ValidationRequest{UID: "TestValidateImmutable", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Operation: "UPDATE", Object: object, OldObject: sameObject}); !result.Allowed() {
+		t.Errorf("Immutable rule should accept objects with unchanged value: %s", result.Messages())
+	}
+
+	expected := "Label team can't be changed from 'bar' to 'foo'"
+	if messages := validator.Validate(&ValidationRequest{UID: "TestValidateImmutable", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Operation: "UPDATE", Object: object, OldObject: oldObject}).Messages(); len(messages) != 1 || messages[0] != expected {
+		t.Errorf("Immutable rule should reject objects with changed value. Expected: '%s', got: %v", expected, messages)
+	}
+}
+
+func TestAddRuleUnsupportedKindOperation(t *testing.T) {
+	rule := ConfigRule{
+		Name:     "TestAddRuleUnsupportedKindOperation",
+		Jsonpath: "{}",
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, []string{"PATCH"}, rule); err == nil {
+		t.Errorf("Unsupported operation of the kind shouldn't be accepted")
+	}
+}
+
+func TestHasOperation(t *testing.T) {
+	rule := ConfigRule{
+		Name:     "TestHasOperation",
+		Jsonpath: "{.metadata.labels.protected}",
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, []string{"DELETE"}, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule: %s", err)
+	}
+
+	if !validator.HasOperation(metav1.GroupVersionKind{Kind: "Foo"}, "DELETE") {
+		t.Errorf("Validator should have rules for DELETE operation")
+	}
+
+	if validator.HasOperation(metav1.GroupVersionKind{Kind: "Foo"}, "CREATE") {
+		t.Errorf("Validator shouldn't have rules for CREATE operation")
+	}
+}
+
+func TestAddRuleUnsupportedOperation(t *testing.T) {
+	rule := ConfigRule{
+		Name:       "TestAddRuleUnsupportedOperation",
+		Operations: []string{"PATCH"},
+		Jsonpath:   "{}",
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err == nil {
+		t.Errorf("Rule with unsupported operation shouldn't be added")
+	}
+}
+
+func TestValidateOperations(t *testing.T) {
+	rule := ConfigRule{
+		Name:       "TestValidateOperations",
+		Operations: []string{"CREATE"},
+		Jsonpath:   "{.metadata.labels.team}",
+		Match:      "required",
+		Message:    "Label team is required",
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule: %s", err)
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"metadata":{"labels":{"foo":"bar"}}}`), &object); err != nil {
+		t.Errorf("Deserializing should not fail")
+	}
+
+	if result := validator.Validate(&ValidationRequest{UID: "TestValidateOperations", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Operation: "CREATE", Object: object}); result.Allowed() {
+		t.Errorf("Rule scoped to CREATE should be applied on CREATE")
+	}
+
+	if result := validator.Validate(&ValidationRequest{UID: "TestValidateOperations", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Operation: "UPDATE", Object: object, OldObject: object}); !result.Allowed() {
+		t.Errorf("Rule scoped to CREATE should not be applied on UPDATE: %s", result.Messages())
+	}
+}
+
+func TestAddRuleImmutableDefaultOperations(t *testing.T) {
+	rule := ConfigRule{
+		Name:     "TestAddRuleImmutableDefaultOperations",
+		Type:     "immutable",
+		Jsonpath: "{.metadata.labels.team}",
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule: %s", err)
+	}
+
+	operations := validator.rulesFor(metav1.GroupVersionKind{Kind: "Foo"})[0].operations
+	if len(operations) != 1 || !operations["UPDATE"] {
+		t.Errorf("Immutable rule should only be applied on UPDATE by default, got: %v", operations)
+	}
+}
+
+func TestAddRuleImmutableOnCreate(t *testing.T) {
+	rule := ConfigRule{
+		Name:       "TestAddRuleImmutableOnCreate",
+		Type:       "immutable",
+		Operations: []string{"CREATE", "UPDATE"},
+		Jsonpath:   "{.metadata.labels.team}",
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err == nil {
+		t.Errorf("Immutable rule applied on CREATE shouldn't be added")
+	}
+}
+
+func TestValidateNamespaces(t *testing.T) {
+	rule := ConfigRule{
+		Name:              "TestValidateNamespaces",
+		Jsonpath:          "{.spec.hostNetwork}",
+		Regexp:            "true",
+		Namespaces:        []string{"prod-*", "staging"},
+		ExcludeNamespaces: []string{"prod-system"},
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule: %s", err)
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"spec":{"hostNetwork":true}}`), &object); err != nil {
+		t.Errorf("Deserializing should not fail")
+	}
+
+	namespaces := map[string]bool{
+		"prod-foo":    false,
+		"staging":     false,
+		"prod-system": true,
+		"dev":         true,
+		"":            false,
+	}
+	for namespace, allowed := range namespaces {
+		if result := validator.Validate(&ValidationRequest{UID: "TestValidateNamespaces", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Namespace: namespace, Operation: "CREATE", Object: object}); result.Allowed() != allowed {
+			t.Errorf("Expected object in namespace '%s' to be allowed: %t, got: %t", namespace, allowed, result.Allowed())
+		}
+	}
+}
+
+func TestAddRuleInvalidNamespacePattern(t *testing.T) {
+	rule := ConfigRule{
+		Name:              "TestAddRuleInvalidNamespacePattern",
+		Jsonpath:          "{}",
+		ExcludeNamespaces: []string{"kube-["},
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err == nil {
+		t.Errorf("Rule with invalid namespace pattern shouldn't be added")
+	}
+}
+
+func TestAddRuleInvalidNamespaceSelector(t *testing.T) {
+	rule := ConfigRule{
+		Name:     "TestAddRuleInvalidNamespaceSelector",
+		Jsonpath: "{}",
+		NamespaceSelector: &LabelSelector{
+			MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "environment", Operator: "Foo"}},
+		},
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err == nil {
+		t.Errorf("Rule with invalid namespace selector shouldn't be added")
+	}
+}
+
+func TestValidateNamespaceSelector(t *testing.T) {
+	rule := ConfigRule{
+		Name:     "TestValidateNamespaceSelector",
+		Jsonpath: "{.spec.hostNetwork}",
+		Regexp:   "true",
+		NamespaceSelector: &LabelSelector{
+			MatchLabels: map[string]string{"environment": "production"},
+		},
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule: %s", err)
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"spec":{"hostNetwork":true}}`), &object); err != nil {
+		t.Errorf("Deserializing should not fail")
+	}
+
+	production := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "foo", Labels: map[string]string{"environment": "production"}}}
+	if result := validator.Validate(&ValidationRequest{UID: "TestValidateNamespaceSelector", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Namespace: "foo", Operation: "CREATE", Object: object, NamespaceObject: production}); result.Allowed() {
+		t.Errorf("Rule should be applied in namespace matching selector")
+	}
+
+	development := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "bar", Labels: map[string]string{"environment": "development"}}}
+	if result := validator.Validate(&ValidationRequest{UID: "TestValidateNamespaceSelector", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Namespace: "bar", Operation: "CREATE", Object: object, NamespaceObject: development}); !result.Allowed() {
+		t.Errorf("Rule should not be applied in namespace not matching selector: %s", result.Messages())
+	}
+
+	if messages := validator.Validate(&ValidationRequest{UID: "TestValidateNamespaceSelector", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Namespace: "baz", Operation: "CREATE", Object: object}).Messages(); len(messages) != 1 || messages[0] != "Failed to validate object" {
+		t.Errorf("Rule should reject objects in namespaces with unknown labels, got: %v", messages)
+	}
+}
+
+func TestValidateObjectSelector(t *testing.T) {
+	rule := ConfigRule{
+		Name:     "TestValidateObjectSelector",
+		Jsonpath: "{.spec.hostNetwork}",
+		Regexp:   "true",
+		ObjectSelector: &LabelSelector{
+			MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "tier", Operator: metav1.LabelSelectorOpIn, Values: []string{"frontend", "backend"}}},
+		},
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule: %s", err)
+	}
+	var frontend, system map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"metadata":{"labels":{"tier":"frontend"}},"spec":{"hostNetwork":true}}`), &frontend); err != nil {
+		t.Errorf("Deserializing should not fail")
+	}
+	if err := json.Unmarshal([]byte(`{"metadata":{"labels":{"tier":"system"}},"spec":{"hostNetwork":true}}`), &system); err != nil {
+		t.Errorf("Deserializing should not fail")
+	}
+
+	if result := validator.Validate(&ValidationRequest{UID: "TestValidateObjectSelector", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Operation: "CREATE", Object: frontend}); result.Allowed() {
+		t.Errorf("Rule should be applied to object matching selector")
+	}
+
+	if result := validator.Validate(&ValidationRequest{UID: "TestValidateObjectSelector", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Operation: "CREATE", Object: system}); !result.Allowed() {
+		t.Errorf("Rule should not be applied to object not matching selector: %s", result.Messages())
+	}
+
+	if result := validator.Validate(&ValidationRequest{UID: "TestValidateObjectSelector", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Operation: "UPDATE", Object: system, OldObject: frontend}); result.Allowed() {
+		t.Errorf("Rule should be applied on update if existing object matches selector")
+	}
+}
+
+func TestAddRuleInvalidObjectSelector(t *testing.T) {
+	rule := ConfigRule{
+		Name:     "TestAddRuleInvalidObjectSelector",
+		Jsonpath: "{}",
+		ObjectSelector: &LabelSelector{
+			MatchLabels: map[string]string{"tier": "front end"},
+		},
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err == nil {
+		t.Errorf("Rule with invalid object selector shouldn't be added")
+	}
+}
+
+func TestValidateExemptions(t *testing.T) {
+	rule := ConfigRule{
+		Name:                  "TestValidateExemptions",
+		Jsonpath:              "{.metadata.name}",
+		ExemptUsers:           []string{"admin-*"},
+		ExemptGroups:          []string{"system:masters"},
+		ExemptServiceAccounts: []string{"ci/*"},
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule: %s", err)
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"metadata":{"name":"foo"}}`), &object); err != nil {
+		t.Errorf("Deserializing should not fail")
+	}
+
+	users := map[string]struct {
+		user   authenticationv1.UserInfo
+		exempt bool
+	}{
+		"user":                      {authenticationv1.UserInfo{Username: "alice", Groups: []string{"developers"}}, false},
+		"user matching pattern":     {authenticationv1.UserInfo{Username: "admin-bob"}, true},
+		"group":                     {authenticationv1.UserInfo{Username: "alice", Groups: []string{"developers", "system:masters"}}, true},
+		"service account":           {authenticationv1.UserInfo{Username: "system:serviceaccount:ci:deployer"}, true},
+		"other service account":     {authenticationv1.UserInfo{Username: "system:serviceaccount:default:deployer"}, false},
+		"user like service account": {authenticationv1.UserInfo{Username: "ci/deployer"}, false},
+	}
+	for name, test := range users {
+		if result := validator.Validate(&ValidationRequest{UID: "TestValidateExemptions", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Operation: "CREATE", UserInfo: test.user, Object: object}); result.Allowed() != test.exempt {
+			t.Errorf("Expected %s to be exempted: %t, got: %t", name, test.exempt, result.Allowed())
+		}
+	}
+}
+
+func TestValidateRuleExemptionsMetric(t *testing.T) {
+	rule := ConfigRule{
+		Name:        "TestValidateRuleExemptionsMetric",
+		Jsonpath:    "{.metadata.name}",
+		ExemptUsers: []string{"admin"},
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule: %s", err)
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"metadata":{"name":"foo"}}`), &object); err != nil {
+		t.Errorf("Deserializing should not fail")
+	}
+
+	counter := ruleExemptions.WithLabelValues("Foo", "TestValidateRuleExemptionsMetric")
+	before := testutil.ToFloat64(counter)
+
+	if result := validator.Validate(&ValidationRequest{UID: "TestValidateRuleExemptionsMetric", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Operation: "CREATE", UserInfo: authenticationv1.UserInfo{Username: "admin"}, Object: object}); !result.Allowed() {
+		t.Errorf("Validating object sent by exempted user should pass: %s", result.Messages())
+	}
+
+	if after := testutil.ToFloat64(counter); after != before+1 {
+		t.Errorf("Rule exemption should be counted. Expected %v, got %v", before+1, after)
+	}
+}
+
+func TestAddRuleInvalidServiceAccountPattern(t *testing.T) {
+	rule := ConfigRule{
+		Name:                  "TestAddRuleInvalidServiceAccountPattern",
+		Jsonpath:              "{}",
+		ExemptServiceAccounts: []string{"deployer"},
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err == nil {
+		t.Errorf("Rule with service account pattern without namespace shouldn't be added")
+	}
+}
+
+func TestValidateFilterExpression(t *testing.T) {
+	rule := ConfigRule{
+		Name:     "TestValidateFilterExpression",
+		Jsonpath: `{.spec.containers[?(@.image !~ /^registry\.corp\// && @.name != 'sidecar')].name}`,
+		Message:  "Container {{.Value}} must use image from registry.corp",
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule: %s", err)
+	}
+	var valid, invalid map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"spec":{"containers":[{"name":"web","image":"registry.corp/web"},{"name":"sidecar","image":"docker.io/proxy"}]}}`), &valid); err != nil {
+		t.Errorf("Deserializing should not fail")
+	}
+	if err := json.Unmarshal([]byte(`{"spec":{"containers":[{"name":"web","image":"docker.io/web"},{"name":"sidecar","image":"docker.io/proxy"}]}}`), &invalid); err != nil {
+		t.Errorf("Deserializing should not fail")
+	}
+
+	if result := validator.Validate(&ValidationRequest{UID: "TestValidateFilterExpression", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Operation: "CREATE", Object: valid}); !result.Allowed() {
+		t.Errorf("Object not matching filter should pass: %s", result.Messages())
+	}
+
+	expected := "Container web must use image from registry.corp"
+	if messages := validator.Validate(&ValidationRequest{UID: "TestValidateFilterExpression", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Operation: "CREATE", Object: invalid}).Messages(); len(messages) != 1 || messages[0] != expected {
+		t.Errorf("Object matching filter should be rejected. Expected: '%s', got: %v", expected, messages)
+	}
+}
+
+func TestAddRuleInvalidFilterExpression(t *testing.T) {
+	rule := ConfigRule{
+		Name:     "TestAddRuleInvalidFilterExpression",
+		Jsonpath: "{.spec.containers[?(@.image =~ 'foo')].name}",
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err == nil {
+		t.Errorf("Rule with invalid filter expression shouldn't be added")
+	}
+}
+
+func TestValidateCEL(t *testing.T) {
+	rule := ConfigRule{
+		Name:    "TestValidateCEL",
+		Cel:     "!has(object.spec.hostNetwork) || !object.spec.hostNetwork || object.spec.containers.all(c, has(c.ports) && c.ports.all(p, has(p.hostPort)))",
+		Message: "{{.Name}} uses host network, so host ports must be set",
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Pod"}, nil, rule); err != nil {
+		t.Fatalf("Validator shouldn't fail adding rule: %s", err)
+	}
+
+	objects := map[string]bool{
+		`{"spec":{"containers":[{"ports":[{"containerPort":80}]}]}}`:                                  true,
+		`{"spec":{"hostNetwork":true,"containers":[{"ports":[{"containerPort":80,"hostPort":80}]}]}}`: true,
+		`{"spec":{"hostNetwork":true,"containers":[{"ports":[{"containerPort":80}]}]}}`:               false,
+		`{"spec":{"hostNetwork":true,"containers":[{"name":"foo"}]}}`:                                 false,
+		`{"spec":{"hostNetwork":false,"containers":[{"ports":[{"containerPort":80}]}]}}`:              true,
+	}
+
+	for data, allowed := range objects {
+		var object map[string]interface{}
+		if err := json.Unmarshal([]byte(data), &object); err != nil {
+			t.Fatalf("Deserializing should not fail: %s", err)
+		}
+
+		violations := validator.Validate(&ValidationRequest{UID: "TestValidateCEL", Kind: metav1.GroupVersionKind{Kind: "Pod"}, Name: "foo", Operation: "CREATE", Object: object})
+		if violations.Allowed() != allowed {
+			t.Errorf("Expected object %s to be allowed: %t, got violations: %v", data, allowed, violations.Messages())
+		}
+		if !allowed && (len(violations) != 1 || violations[0].Message != "foo uses host network, so host ports must be set") {
+			t.Errorf("Expected rendered message of CEL rule, got: %v", violations.Messages())
+		}
+	}
+}
+
+func TestValidateCELError(t *testing.T) {
+	rule := ConfigRule{
+		Name: "TestValidateCELError",
+		Type: "cel",
+		Cel:  "object.spec.replicas < 10",
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err != nil {
+		t.Fatalf("Validator shouldn't fail adding rule: %s", err)
+	}
+
+	object := map[string]interface{}{"spec": map[string]interface{}{}}
+	violations := validator.Validate(&ValidationRequest{UID: "TestValidateCELError", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Operation: "CREATE", Object: object})
+	if violations.Allowed() || violations[0].Message != "Failed to validate object" {
+		t.Errorf("Object should be rejected if CEL expression can't be evaluated, got: %v", violations.Messages())
+	}
+}
+
+func TestValidateCELOldObject(t *testing.T) {
+	rule := ConfigRule{
+		Name:       "TestValidateCELOldObject",
+		Operations: []string{"UPDATE"},
+		Cel:        "object.spec.replicas >= oldObject.spec.replicas || request.userInfo.username == 'admin'",
+		Message:    "Scaling down is not allowed",
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err != nil {
+		t.Fatalf("Validator shouldn't fail adding rule: %s", err)
+	}
+
+	object := map[string]interface{}{"spec": map[string]interface{}{"replicas": int64(1)}}
+	oldObject := map[string]interface{}{"spec": map[string]interface{}{"replicas": int64(3)}}
+
+	req := &ValidationRequest{UID: "TestValidateCELOldObject", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Operation: "UPDATE", Object: object, OldObject: oldObject}
+	if violations := validator.Validate(req); violations.Allowed() {
+		t.Errorf("Scaling down should be rejected")
+	}
+
+	req.UserInfo = authenticationv1.UserInfo{Username: "admin"}
+	if violations := validator.Validate(req); !violations.Allowed() {
+		t.Errorf("Scaling down by admin should be accepted, got: %v", violations.Messages())
+	}
+}
+
+func TestAddRuleCELInvalid(t *testing.T) {
+	rules := map[string]ConfigRule{
+		"invalid expression":   {Cel: "object.spec =="},
+		"non-boolean":          {Cel: "size(object.metadata.name)"},
+		"with JSONPath":        {Cel: "true", Jsonpath: "{.metadata.name}"},
+		"with regexp":          {Cel: "true", Regexp: "foo"},
+		"with forEach":         {Cel: "true", ForEach: true},
+		"empty expression":     {Type: "cel"},
+		"with other rule type": {Type: "immutable", Cel: "true", Jsonpath: "{.metadata.name}"},
+		"with match rule type": {Type: "match", Cel: "true", Jsonpath: "{.metadata.name}"},
+	}
+
+	for name, rule := range rules {
+		rule.Name = "TestAddRuleCELInvalid"
+		validator := NewValidator()
+		if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err == nil {
+			t.Errorf("CEL rule %s shouldn't be added", name)
+		}
+	}
+}
+
+func TestValidateRego(t *testing.T) {
+	rule := ConfigRule{
+		Name:        "TestValidateRego",
+		Rego:        regoTestPolicy,
+		Enforcement: "warn",
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Pod"}, nil, rule); err != nil {
+		t.Fatalf("Validator shouldn't fail adding rule: %s", err)
+	}
+
+	req := regoTestRequest()
+	req.Kind = metav1.GroupVersionKind{Kind: "Pod"}
+
+	violations := validator.Validate(req)
+	if len(violations) != 2 {
+		t.Fatalf("Each message of deny rule should be separate violation, got: %+v", violations)
+	}
+	for _, violation := range violations {
+		if violation.Rule != "TestValidateRego" || violation.Enforcement != "warn" || violation.Message == "" {
+			t.Errorf("Violation should contain rule name, enforcement and message of deny rule, got: %+v", violation)
+		}
+	}
+}
+
+func TestValidateRegoAccept(t *testing.T) {
+	rule := ConfigRule{
+		Name: "TestValidateRegoAccept",
+		Type: "rego",
+		Rego: regoTestPolicy,
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Pod"}, nil, rule); err != nil {
+		t.Fatalf("Validator shouldn't fail adding rule: %s", err)
+	}
+
+	req := regoTestRequest()
+	req.Kind = metav1.GroupVersionKind{Kind: "Pod"}
+	req.Operation = "CREATE"
+	req.OldObject = nil
+	req.Object = map[string]interface{}{
+		"spec": map[string]interface{}{
+			"containers": []interface{}{
+				map[string]interface{}{"name": "web", "image": "registry.corp/web"},
+			},
+		},
+	}
+
+	if violations := validator.Validate(req); len(violations) != 0 {
+		t.Errorf("Object not denied by policy should be accepted, got: %v", violations.Messages())
+	}
+}
+
+func TestAddRuleRegoInvalid(t *testing.T) {
+	rules := map[string]ConfigRule{
+		"invalid policy":       {Rego: "package foo\n\ndeny contains msg if {\n"},
+		"with JSONPath":        {Rego: regoTestPolicy, Jsonpath: "{.metadata.name}"},
+		"with regexp":          {Rego: regoTestPolicy, Regexp: "foo"},
+		"with message":         {Rego: regoTestPolicy, Message: "foo"},
+		"empty policy":         {Type: "rego"},
+		"file not read":        {RegoFile: "policy.rego"},
+		"with match rule type": {Type: "match", Rego: regoTestPolicy, Jsonpath: "{.metadata.name}"},
+		"with CEL":             {Type: "cel", Rego: regoTestPolicy, Cel: "true"},
+	}
+
+	for name, rule := range rules {
+		rule.Name = "TestAddRuleRegoInvalid"
+		validator := NewValidator()
+		if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err == nil {
+			t.Errorf("Rego rule %s shouldn't be added", name)
+		}
+	}
+}
+
+func TestValidateComposite(t *testing.T) {
+	rule := ConfigRule{
+		Name: "TestValidateComposite",
+		AllOf: []Condition{
+			{Jsonpath: "{.spec.containers[*].securityContext.privileged}", Regexp: "true"},
+			{Not: &Condition{Jsonpath: "{.metadata.namespace}", Regexp: "^system-"}},
+		},
+		Message: "Privileged containers are only allowed in system namespaces, got {{.Object.metadata.namespace}}",
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Pod"}, nil, rule); err != nil {
+		t.Fatalf("Validator shouldn't fail adding rule: %s", err)
+	}
+
+	objects := map[string]bool{
+		`{"metadata":{"namespace":"prod"},"spec":{"containers":[{"securityContext":{"privileged":true}}]}}`:       false,
+		`{"metadata":{"namespace":"system-dns"},"spec":{"containers":[{"securityContext":{"privileged":true}}]}}`: true,
+		`{"metadata":{"namespace":"prod"},"spec":{"containers":[{"securityContext":{"privileged":false}}]}}`:      true,
+	}
+
+	for data, allowed := range objects {
+		var object map[string]interface{}
+		if err := json.Unmarshal([]byte(data), &object); err != nil {
+			t.Fatalf("Deserializing should not fail: %s", err)
+		}
+
+		violations := validator.Validate(&ValidationRequest{UID: "TestValidateComposite", Kind: metav1.GroupVersionKind{Kind: "Pod"}, Operation: "CREATE", Object: object})
+		if violations.Allowed() != allowed {
+			t.Errorf("Expected object %s to be allowed: %t, got violations: %v", data, allowed, violations.Messages())
+		}
+		if !allowed && (len(violations) != 1 || violations[0].Message != "Privileged containers are only allowed in system namespaces, got prod") {
+			t.Errorf("Expected rendered message of composite rule, got: %v", violations.Messages())
+		}
+	}
+}
+
+func TestValidateCompositeNone(t *testing.T) {
+	rule := ConfigRule{
+		Name: "TestValidateCompositeNone",
+		Not: &Condition{
+			AnyOf: []Condition{
+				{Jsonpath: "{.metadata.labels.team}"},
+				{Jsonpath: "{.metadata.annotations.owner}"},
+			},
+		},
+		Message: "Either team label or owner annotation must be set",
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err != nil {
+		t.Fatalf("Validator shouldn't fail adding rule: %s", err)
+	}
+
+	objects := map[string]bool{
+		`{"metadata":{"labels":{"team":"a"}}}`:         true,
+		`{"metadata":{"annotations":{"owner":"bob"}}}`: true,
+		`{"metadata":{"labels":{"app":"a"}}}`:          false,
+	}
+
+	for data, allowed := range objects {
+		var object map[string]interface{}
+		if err := json.Unmarshal([]byte(data), &object); err != nil {
+			t.Fatalf("Deserializing should not fail: %s", err)
+		}
+
+		violations := validator.Validate(&ValidationRequest{UID: "TestValidateCompositeNone", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Operation: "CREATE", Object: object})
+		if violations.Allowed() != allowed {
+			t.Errorf("Expected object %s to be allowed: %t, got violations: %v", data, allowed, violations.Messages())
+		}
+	}
+}
+
+func TestValidateCompositeError(t *testing.T) {
+	rule := ConfigRule{
+		Name:  "TestValidateCompositeError",
+		AnyOf: []Condition{{Jsonpath: "{.spec.replicas[0]}"}},
+	}
+	validator := NewValidator()
+	if err := validator.AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err != nil {
+		t.Fatalf("Validator shouldn't fail adding rule: %s", err)
+	}
+
+	object := map[string]interface{}{"spec": map[string]interface{}{"replicas": 3}}
+	violations := validator.Validate(&ValidationRequest{UID: "TestValidateCompositeError", Kind: metav1.GroupVersionKind{Kind: "Foo"}, Operation: "CREATE", Object: object})
+	if violations.Allowed() || violations[0].Message != "Failed to validate object" {
+		t.Errorf("Object should be rejected if condition can't be evaluated, got: %v", violations.Messages())
+	}
+}
+
+func TestAddRuleCompositeInvalid(t *testing.T) {
+	condition := Condition{Jsonpath: "{.spec.hostNetwork}"}
+	rules := map[string]ConfigRule{
+		"jsonpath and allOf":   {Name: "foo", Jsonpath: "{.metadata.name}", AllOf: []Condition{condition}},
+		"regexp and anyOf":     {Name: "foo", Regexp: "foo", AnyOf: []Condition{condition}},
+		"forEach and not":      {Name: "foo", ForEach: true, Not: &condition},
+		"immutable rule":       {Name: "foo", Type: "immutable", AllOf: []Condition{condition}},
+		"cel rule":             {Name: "foo", Cel: "true", AnyOf: []Condition{condition}},
+		"invalid subcondition": {Name: "foo", AllOf: []Condition{condition, {Jsonpath: "{.spec", Regexp: "foo"}}},
+	}
+
+	for name, rule := range rules {
+		if err := NewValidator().AddRule(metav1.GroupVersionKind{Kind: "Foo"}, nil, rule); err == nil {
+			t.Errorf("Adding composite rule with %s should fail", name)
+		}
 	}
 }
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/golang/glog"
+)
+
+// FileWatcher calls given function every time one of watched files changes
+// Parent directories of the files are watched rather than files themselves, so atomic
+// symlink swaps done by Kubernetes when updating mounted ConfigMaps and Secrets are detected as well
+type FileWatcher struct {
+	watcher  *fsnotify.Watcher // Watcher of parent directories
+	files    map[string]string // Watched files mapped to their resolved paths
+	onChange func()            // Function called when any of files changes
+	done     chan struct{}     // Closed when watcher is stopped
+}
+
+// NewFileWatcher creates new FileWatcher instance for given files
+// Watching starts when Run method is called
+func NewFileWatcher(onChange func(), files ...string) (*FileWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	fw := &FileWatcher{
+		watcher:  watcher,
+		files:    make(map[string]string),
+		onChange: onChange,
+		done:     make(chan struct{}),
+	}
+
+	dirs := make(map[string]bool)
+	for _, file := range files {
+		file = filepath.Clean(file)
+		fw.files[file] = resolvePath(file)
+
+		dir := filepath.Dir(file)
+		if dirs[dir] {
+			continue
+		}
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return nil, err
+		}
+		dirs[dir] = true
+	}
+
+	return fw, nil
+}
+
+// Run processes file system events until watcher is closed
+func (fw *FileWatcher) Run() {
+	for {
+		select {
+		case event, ok := <-fw.watcher.Events:
+			if !ok {
+				return
+			}
+			if fw.changed(event) {
+				fw.onChange()
+			}
+		case err, ok := <-fw.watcher.Errors:
+			if !ok {
+				return
+			}
+			glog.Errorf("Error while watching files: %v", err)
+		case <-fw.done:
+			return
+		}
+	}
+}
+
+// Close stops watching files
+func (fw *FileWatcher) Close() error {
+	close(fw.done)
+	return fw.watcher.Close()
+}
+
+// changed checks if given event modifies any of watched files, either directly or by changing symlink target
+func (fw *FileWatcher) changed(event fsnotify.Event) bool {
+	// Ignore pure permission changes
+	if event.Op == fsnotify.Chmod {
+		return false
+	}
+
+	changed := false
+	for file, resolved := range fw.files {
+		current := resolvePath(file)
+		if filepath.Clean(event.Name) == file || current != resolved {
+			glog.V(4).Infof("File %s changed (%s)", file, event)
+			fw.files[file] = current
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// resolvePath returns path with all symlinks evaluated or empty string if path can't be resolved
+func resolvePath(path string) string {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return ""
+	}
+	return resolved
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func waitForChange(t *testing.T, changes chan struct{}) {
+	select {
+	case <-changes:
+	case <-time.After(5 * time.Second):
+		t.Errorf("Change of watched file not detected")
+	}
+}
+
+func TestFileWatcherWrite(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(file, []byte("foo"), 0644); err != nil {
+		t.Fatalf("Creating file should not fail: %s", err)
+	}
+
+	changes := make(chan struct{}, 10)
+	watcher, err := NewFileWatcher(func() { changes <- struct{}{} }, file)
+	if err != nil {
+		t.Fatalf("Creating watcher should not fail: %s", err)
+	}
+	go watcher.Run()
+	defer watcher.Close()
+
+	if err := ioutil.WriteFile(file, []byte("bar"), 0644); err != nil {
+		t.Fatalf("Writing file should not fail: %s", err)
+	}
+
+	waitForChange(t, changes)
+}
+
+func TestFileWatcherSymlinkSwap(t *testing.T) {
+	// Mimic layout of ConfigMap mounted as volume
+	dir := t.TempDir()
+	for _, version := range []string{"v1", "v2"} {
+		if err := os.Mkdir(filepath.Join(dir, version), 0755); err != nil {
+			t.Fatalf("Creating directory should not fail: %s", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, version, "config.yaml"), []byte(version), 0644); err != nil {
+			t.Fatalf("Creating file should not fail: %s", err)
+		}
+	}
+	if err := os.Symlink("v1", filepath.Join(dir, "..data")); err != nil {
+		t.Fatalf("Creating symlink should not fail: %s", err)
+	}
+	file := filepath.Join(dir, "config.yaml")
+	if err := os.Symlink(filepath.Join("..data", "config.yaml"), file); err != nil {
+		t.Fatalf("Creating symlink should not fail: %s", err)
+	}
+
+	changes := make(chan struct{}, 10)
+	watcher, err := NewFileWatcher(func() { changes <- struct{}{} }, file)
+	if err != nil {
+		t.Fatalf("Creating watcher should not fail: %s", err)
+	}
+	go watcher.Run()
+	defer watcher.Close()
+
+	// Atomically swap data directory
+	if err := os.Symlink("v2", filepath.Join(dir, "..data_tmp")); err != nil {
+		t.Fatalf("Creating symlink should not fail: %s", err)
+	}
+	if err := os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")); err != nil {
+		t.Fatalf("Swapping symlink should not fail: %s", err)
+	}
+
+	waitForChange(t, changes)
+}
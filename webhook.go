@@ -2,21 +2,36 @@ package main
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/santhosh-tekuri/jsonschema/v6"
 	"gopkg.in/yaml.v2"
-	"k8s.io/api/admission/v1beta1"
-	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	k8syaml "sigs.k8s.io/yaml"
 )
 
+// Key of audit annotation containing violations of rules in audit mode
+const auditAnnotationKey = "audit-violations"
+
 // Initialize serializer
 var (
 	runtimeScheme = runtime.NewScheme()
@@ -24,18 +39,37 @@ var (
 	deserializer  = codecs.UniversalDeserializer()
 )
 
+// Register supported AdmissionReview versions, so deserializer can detect version of received request
+func init() {
+	utilruntime.Must(admissionv1.AddToScheme(runtimeScheme))
+	utilruntime.Must(admissionv1beta1.AddToScheme(runtimeScheme))
+}
+
 // WebhookServer is used to share data between main() and request handlers to avoid global variables
 type WebhookServer struct {
-	server    *http.Server // Webserver reference
-	validator *Validator   // Validator object
+	server              *http.Server         // Webserver reference
+	validator           *Validator           // Validator object
+	configLoaded        bool                 // Whether config file has been loaded successfully at least once
+	mutex               sync.RWMutex         // Protects validator and configLoaded, which change when config is reloaded
+	certificates        *CertificateReloader // Source of served x509 key pair
+	minRules            int                  // Minimum number of loaded rules required to report readiness
+	certExpiryThreshold time.Duration        // Report not ready if certificate expires sooner than that
+	namespaces          *NamespaceCache      // Source of namespace labels, started once rules using namespace labels are loaded, nil if namespaces can't be looked up
 }
 
 // WhSvrParameters contains Webhook Server parameters passed from ARGV
 type WhSvrParameters struct {
-	port       int    // Webhook server port
-	certFile   string // Path to the x509 certificate for https
-	keyFile    string // Path to the x509 private key matching `CertFile`
-	configFile string // Path to configuration file
+	port        int    // Webhook server port
+	metricsPort int    // Metrics server port
+	certFile    string // Path to the x509 certificate for https
+	keyFile     string // Path to the x509 private key matching `CertFile`
+	configFile  string // Path to configuration file
+	strict      bool   // Fail on invalid configuration instead of skipping invalid rules
+
+	minRules            int           // Minimum number of loaded rules required to report readiness
+	certExpiryThreshold time.Duration // Report not ready if certificate expires sooner than that
+
+	kubeconfig string // Path to kubeconfig file used for looking up namespaces, in-cluster configuration is used if empty
 }
 
 // ConfigFile is used for deserializing config file
@@ -45,94 +79,478 @@ type ConfigFile struct {
 
 // Kind is used for deserializing config file
 type Kind struct {
-	Name  string       `yaml:"name"`  // Name of the Kind to validate
-	Rules []ConfigRule `yaml:"rules"` // Array of validation rules
+	Name                  string         `yaml:"name"`                            // Name of the Kind to validate
+	Group                 string         `yaml:"group,omitempty"`                 // API group of the Kind, any group matches if empty or '*', 'core' selects core group
+	Version               string         `yaml:"version,omitempty"`               // API version of the Kind, any version matches if empty or '*'
+	Operations            []string       `yaml:"operations,omitempty"`            // Operations rules of the Kind apply to, CREATE and UPDATE if empty
+	ObjectSelector        *LabelSelector `yaml:"objectSelector,omitempty"`        // Labels of objects rules of the Kind apply to
+	ExemptUsers           []string       `yaml:"exemptUsers,omitempty"`           // Glob patterns of users exempted from rules of the Kind
+	ExemptGroups          []string       `yaml:"exemptGroups,omitempty"`          // Glob patterns of groups exempted from rules of the Kind
+	ExemptServiceAccounts []string       `yaml:"exemptServiceAccounts,omitempty"` // Glob patterns of service accounts exempted from rules of the Kind, in namespace/name format
+	Schema                interface{}    `yaml:"schema,omitempty"`                // JSON Schema or OpenAPI v3 schema, which objects of the Kind must conform to
+	SchemaFile            string         `yaml:"schemaFile,omitempty"`            // Path to JSON or YAML file with schema, relative to config file, used instead of inline schema
+	Rules                 []ConfigRule   `yaml:"rules"`                           // Array of validation rules
+}
+
+// GroupVersionKind converts Kind settings into GroupVersionKind used by validator
+func (k Kind) GroupVersionKind() metav1.GroupVersionKind {
+	gvk := metav1.GroupVersionKind{
+		Group:   k.Group,
+		Version: k.Version,
+		Kind:    k.Name,
+	}
+
+	// Empty group and version in config file means any group and version,
+	// so core group needs to be selected explicitly
+	switch gvk.Group {
+	case "":
+		gvk.Group = wildcard
+	case "core":
+		gvk.Group = ""
+	}
+
+	if gvk.Version == "" {
+		gvk.Version = wildcard
+	}
+
+	return gvk
 }
 
 // ConfigRule holds individual rule settings
 type ConfigRule struct {
-	Name     string `yaml:"name"`              // Rule name
-	Jsonpath string `yaml:"jsonpath"`          // JSONPath query to extract value from validated object
-	Regexp   string `yaml:"regexp,omitempty"`  // Regexp, which will be applied on extracted value
-	Message  string `yaml:"message,omitempty"` // Error message returned to user when validation rejects object
+	Name                  string         `yaml:"name"`                            // Rule name
+	Type                  string         `yaml:"type,omitempty"`                  // One of 'match' (default) to check query output, 'immutable' to reject changes of query output on update, 'cel' to evaluate CEL expression or 'rego' to evaluate Rego policy
+	Operations            []string       `yaml:"operations,omitempty"`            // Operations the rule applies to, defaults to operations of the Kind
+	Jsonpath              string         `yaml:"jsonpath,omitempty"`              // JSONPath query to extract value from validated object
+	Cel                   string         `yaml:"cel,omitempty"`                   // CEL expression returning true for valid objects, used instead of JSONPath query
+	Rego                  string         `yaml:"rego,omitempty"`                  // Rego module with deny rule returning rejection messages, used instead of JSONPath query
+	RegoFile              string         `yaml:"regoFile,omitempty"`              // Path to Rego module, relative to config file, used instead of inline module
+	Regexp                string         `yaml:"regexp,omitempty"`                // Regexp, which will be applied on extracted value
+	Match                 string         `yaml:"match,omitempty"`                 // Either 'forbidden' (default) to reject matching values or 'required' to reject values which don't match
+	ForEach               bool           `yaml:"forEach,omitempty"`               // Apply regexp on each JSONPath result separately instead of on joined output
+	Namespaces            []string       `yaml:"namespaces,omitempty"`            // Glob patterns of namespaces the rule applies to, all namespaces if empty
+	ExcludeNamespaces     []string       `yaml:"excludeNamespaces,omitempty"`     // Glob patterns of namespaces the rule doesn't apply to
+	NamespaceSelector     *LabelSelector `yaml:"namespaceSelector,omitempty"`     // Labels of namespaces the rule applies to
+	ObjectSelector        *LabelSelector `yaml:"objectSelector,omitempty"`        // Labels of objects the rule applies to, in addition to object selector of the Kind
+	ExemptUsers           []string       `yaml:"exemptUsers,omitempty"`           // Glob patterns of users exempted from the rule, in addition to exemptions of the Kind
+	ExemptGroups          []string       `yaml:"exemptGroups,omitempty"`          // Glob patterns of groups exempted from the rule, in addition to exemptions of the Kind
+	ExemptServiceAccounts []string       `yaml:"exemptServiceAccounts,omitempty"` // Glob patterns of service accounts exempted from the rule, in namespace/name format
+	Enforcement           string         `yaml:"enforcement,omitempty"`           // One of 'deny' (default), 'warn' or 'audit', controls what happens when object violates the rule
+	AllOf                 []Condition    `yaml:"allOf,omitempty"`                 // Conditions, which must all hold for object to be rejected, used instead of JSONPath query
+	AnyOf                 []Condition    `yaml:"anyOf,omitempty"`                 // Conditions, at least one of which must hold for object to be rejected, used instead of JSONPath query
+	Not                   *Condition     `yaml:"not,omitempty"`                   // Condition, which must not hold for object to be rejected, used instead of JSONPath query
+	Message               string         `yaml:"message,omitempty"`               // Error message returned to user when validation rejects object, may be a text/template
+
+	schema *jsonschema.Schema // Compiled schema of the Kind, only set for rule created from it
 }
 
-// Stats, reads and parses config file
-func (whsvr *WebhookServer) readConfig(configFile string) {
-	// Stat config file
-	if _, err := os.Stat(configFile); err == nil {
-		// Read it
-		data, err := ioutil.ReadFile(configFile)
-		if err != nil {
-			glog.Errorf("Failed to read config file: %s", err)
-			return
+// Condition is used for deserializing conditions of composite rules
+// Condition with JSONPath query holds if match rule with the same query, regexp and match would reject the object
+type Condition struct {
+	Jsonpath string      `yaml:"jsonpath,omitempty"` // JSONPath query to extract value from validated object
+	Regexp   string      `yaml:"regexp,omitempty"`   // Regexp, which will be applied on extracted value
+	Match    string      `yaml:"match,omitempty"`    // Either 'forbidden' (default) for condition to hold if value matches or 'required' if it doesn't
+	AllOf    []Condition `yaml:"allOf,omitempty"`    // Conditions, which must all hold
+	AnyOf    []Condition `yaml:"anyOf,omitempty"`    // Conditions, at least one of which must hold
+	Not      *Condition  `yaml:"not,omitempty"`      // Condition, which must not hold
+}
+
+// LabelSelector is metav1.LabelSelector, which can be deserialized from config file
+type LabelSelector metav1.LabelSelector
+
+// UnmarshalYAML deserializes label selector using the same field names as Kubernetes, e.g. matchLabels
+func (ls *LabelSelector) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var selector struct {
+		MatchLabels      map[string]string `yaml:"matchLabels,omitempty"`
+		MatchExpressions []struct {
+			Key      string   `yaml:"key"`
+			Operator string   `yaml:"operator"`
+			Values   []string `yaml:"values,omitempty"`
+		} `yaml:"matchExpressions,omitempty"`
+	}
+	if err := unmarshal(&selector); err != nil {
+		return err
+	}
+
+	ls.MatchLabels = selector.MatchLabels
+	for _, expression := range selector.MatchExpressions {
+		ls.MatchExpressions = append(ls.MatchExpressions, metav1.LabelSelectorRequirement{
+			Key:      expression.Key,
+			Operator: metav1.LabelSelectorOperator(expression.Operator),
+			Values:   expression.Values,
+		})
+	}
+
+	return nil
+}
+
+// And combines label selectors, so only labels matching both of them are selected
+// Nil selector is returned if both selectors are nil
+func (ls *LabelSelector) And(other *LabelSelector) *LabelSelector {
+	if ls == nil {
+		return other
+	}
+	if other == nil {
+		return ls
+	}
+
+	// Labels are converted to expressions, so selectors requiring different values of the same label don't override each other
+	combined := &LabelSelector{}
+	for _, selector := range []*LabelSelector{ls, other} {
+		for key, value := range selector.MatchLabels {
+			combined.MatchExpressions = append(combined.MatchExpressions, metav1.LabelSelectorRequirement{
+				Key:      key,
+				Operator: metav1.LabelSelectorOpIn,
+				Values:   []string{value},
+			})
 		}
+		combined.MatchExpressions = append(combined.MatchExpressions, selector.MatchExpressions...)
+	}
+
+	return combined
+}
+
+// Stats, reads and parses config file and builds new validator from it
+// In strict mode, unknown keys in config file and invalid rules are treated as errors,
+// otherwise invalid rules are only logged and skipped
+func loadConfig(configFile string, strict bool) (*Validator, error) {
+	// Stat config file
+	if _, err := os.Stat(configFile); err != nil {
+		return nil, err
+	}
 
-		// Parse it
-		config := ConfigFile{}
-		err = yaml.Unmarshal([]byte(data), &config)
+	// Read it
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read config file: %s", err)
+	}
+
+	// Parse it
+	unmarshal := yaml.Unmarshal
+	if strict {
+		unmarshal = yaml.UnmarshalStrict
+	}
+	config := ConfigFile{}
+	err = unmarshal([]byte(data), &config)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse config file: %s", err)
+	}
+
+	validator := NewValidator()
+
+	// Iterate over kinds and rules and add them to validator
+	var errors []string
+	for _, kind := range config.Kinds {
+		gvk := kind.GroupVersionKind()
+		target, operations := validator, kind.Operations
+		if _, err := parseOperations(kind.Operations); err != nil {
+			// Rules of the kind can't be applied, if it's not known when to apply them,
+			// but they are still parsed into discarded validator, so all invalid rules are reported
+			glog.Errorf("Parsing operations for kind '%s' failed: %s", gvk, err)
+			errors = append(errors, fmt.Sprintf("operations for kind '%s': %s", gvk, err))
+			target, operations = NewValidator(), nil
+		}
+		// Objects are validated against schema of the kind using rule, so it's scoped and reported like other rules
+		rules := kind.Rules
+		schema, err := readSchema(configFile, kind)
 		if err != nil {
-			glog.Errorf("Failed to parse config file: %s", err)
-			return
+			glog.Errorf("Reading schema for kind '%s' failed: %s", gvk, err)
+			errors = append(errors, fmt.Sprintf("schema for kind '%s': %s", gvk, err))
+		} else if schema != nil {
+			rules = append([]ConfigRule{{Name: schemaRuleName, Type: typeSchema, schema: schema}}, rules...)
 		}
-
-		// Iterate over kinds and rules and add them to validator
-		for _, kind := range config.Kinds {
-			for _, rule := range kind.Rules {
-				if err := whsvr.validator.AddRule(kind.Name, rule); err != nil {
-					glog.Errorf("Parsing rule '%s' for kind '%s' failed: %s", rule.Name, kind.Name, err)
-				}
+		for _, rule := range rules {
+			rule.ObjectSelector = kind.ObjectSelector.And(rule.ObjectSelector)
+			rule.ExemptUsers = append(append([]string{}, kind.ExemptUsers...), rule.ExemptUsers...)
+			rule.ExemptGroups = append(append([]string{}, kind.ExemptGroups...), rule.ExemptGroups...)
+			rule.ExemptServiceAccounts = append(append([]string{}, kind.ExemptServiceAccounts...), rule.ExemptServiceAccounts...)
+			if err := readRegoFile(configFile, &rule); err != nil {
+				glog.Errorf("Reading Rego policy of rule '%s' for kind '%s' failed: %s", rule.Name, gvk, err)
+				errors = append(errors, fmt.Sprintf("rule '%s' for kind '%s': %s", rule.Name, gvk, err))
+				continue
+			}
+			if err := target.AddRule(gvk, operations, rule); err != nil {
+				glog.Errorf("Parsing rule '%s' for kind '%s' failed: %s", rule.Name, gvk, err)
+				errors = append(errors, fmt.Sprintf("rule '%s' for kind '%s': %s", rule.Name, gvk, err))
 			}
 		}
-	} else if os.IsNotExist(err) {
-		glog.Errorf("Config file not found, no validation will be performed")
+	}
+
+	if strict && len(errors) > 0 {
+		return nil, fmt.Errorf("Found %d invalid rules: %s", len(errors), strings.Join(errors, "; "))
+	}
+
+	return validator, nil
+}
+
+// Reads Rego module of the rule from file, so it can be compiled together with other rules
+// Relative paths are resolved from directory of config file
+func readRegoFile(configFile string, rule *ConfigRule) error {
+	if rule.RegoFile == "" {
+		return nil
+	}
+
+	if rule.Rego != "" {
+		return fmt.Errorf("Rego policy can't be set both inline and using file")
+	}
+
+	file := rule.RegoFile
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(filepath.Dir(configFile), file)
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("Failed to read Rego policy file: %s", err)
+	}
+	rule.Rego = string(data)
+
+	return nil
+}
+
+// Reads and compiles schema of the kind, either inline or from file, nil is returned if kind has no schema
+// Schemas may be written in YAML, so they are converted to JSON first
+func readSchema(configFile string, kind Kind) (*jsonschema.Schema, error) {
+	if kind.Schema == nil && kind.SchemaFile == "" {
+		return nil, nil
+	}
+
+	if kind.Schema != nil && kind.SchemaFile != "" {
+		return nil, fmt.Errorf("Schema can't be set both inline and using file")
+	}
+
+	var location string
+	var data []byte
+	var err error
+	if kind.SchemaFile != "" {
+		location = kind.SchemaFile
+		if !filepath.IsAbs(location) {
+			location = filepath.Join(filepath.Dir(configFile), location)
+		}
+		if data, err = ioutil.ReadFile(location); err != nil {
+			return nil, fmt.Errorf("Failed to read schema file: %s", err)
+		}
 	} else {
-		glog.Errorf("Failed to stat config file: %s", err)
+		// Inline schema is identified by config file and kind, so references to other files are resolved from its directory
+		location = filepath.Join(filepath.Dir(configFile), fmt.Sprintf("%s.schema.json", kind.Name))
+		if data, err = yaml.Marshal(kind.Schema); err != nil {
+			return nil, fmt.Errorf("Failed to serialize schema: %s", err)
+		}
 	}
+
+	document, err := k8syaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse schema: %s", err)
+	}
+
+	schema, err := compileSchema(location, document)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to compile schema: %s", err)
+	}
+
+	return schema, nil
+}
+
+// Reads config file and replaces current validator with the one built from it
+// If config file can't be read or parsed, current validator is kept
+func (whsvr *WebhookServer) readConfig(configFile string, strict bool) error {
+	validator, err := loadConfig(configFile, strict)
+	if err != nil {
+		return err
+	}
+
+	whsvr.mutex.Lock()
+	whsvr.validator = validator
+	whsvr.configLoaded = true
+	whsvr.mutex.Unlock()
+
+	// Namespaces are only watched when needed, as it requires permissions to list them
+	if whsvr.namespaces != nil && validator.UsesNamespaces() {
+		whsvr.namespaces.Start()
+	}
+
+	glog.Infof("Loaded %d rules from config file %s", validator.RuleCount(), configFile)
+
+	return nil
+}
+
+// Reloads config file, logging the result
+func (whsvr *WebhookServer) reloadConfig(configFile string, strict bool) {
+	glog.Infof("Reloading config file %s", configFile)
+
+	if err := whsvr.readConfig(configFile, strict); err != nil {
+		glog.Errorf("Failed to reload config file, keeping previous rules: %s", err)
+		configReloads.WithLabelValues("failure").Inc()
+		return
+	}
+
+	configReloads.WithLabelValues("success").Inc()
+}
+
+// Returns validator, which should be used for validating current request
+func (whsvr *WebhookServer) getValidator() *Validator {
+	whsvr.mutex.RLock()
+	defer whsvr.mutex.RUnlock()
+
+	return whsvr.validator
 }
 
 // NewWebhookServer creates new WebhookServer instance with initialized validator
-func NewWebhookServer(port int, pair tls.Certificate) *WebhookServer {
+// Served certificate is always taken from given CertificateReloader, so it can be rotated
+func NewWebhookServer(port int, certificates *CertificateReloader) *WebhookServer {
 	validator := NewValidator()
 	return &WebhookServer{
 		server: &http.Server{
 			Addr:      fmt.Sprintf(":%v", port),
-			TLSConfig: &tls.Config{Certificates: []tls.Certificate{pair}},
+			TLSConfig: &tls.Config{GetCertificate: certificates.GetCertificate},
 		},
-		validator: validator,
+		validator:    validator,
+		certificates: certificates,
 	}
 }
 
+// Liveness endpoint, always succeeds if server is able to handle requests
+func (whsvr *WebhookServer) healthz(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprint(w, "ok")
+}
+
+// Readiness endpoint, succeeds only if server is able to validate requests
+func (whsvr *WebhookServer) readyz(w http.ResponseWriter, r *http.Request) {
+	if err := whsvr.ready(); err != nil {
+		glog.Errorf("Readiness check failed: %v", err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	fmt.Fprint(w, "ok")
+}
+
+// Checks if config has been loaded with enough rules and if served certificate is valid
+func (whsvr *WebhookServer) ready() error {
+	whsvr.mutex.RLock()
+	configLoaded := whsvr.configLoaded
+	rules := whsvr.validator.RuleCount()
+	namespacesRequired := whsvr.validator.UsesNamespaces()
+	whsvr.mutex.RUnlock()
+
+	if !configLoaded {
+		return fmt.Errorf("Config file not loaded")
+	}
+
+	if rules < whsvr.minRules {
+		return fmt.Errorf("Loaded %d rules, expected at least %d", rules, whsvr.minRules)
+	}
+
+	if namespacesRequired && whsvr.namespaces != nil && !whsvr.namespaces.HasSynced() {
+		return fmt.Errorf("Namespace cache not synced")
+	}
+
+	if whsvr.certificates == nil {
+		return fmt.Errorf("Certificate not loaded")
+	}
+
+	pair, err := whsvr.certificates.GetCertificate(nil)
+	if err != nil {
+		return err
+	}
+
+	cert := pair.Leaf
+	if cert == nil {
+		if cert, err = x509.ParseCertificate(pair.Certificate[0]); err != nil {
+			return fmt.Errorf("Failed to parse certificate: %v", err)
+		}
+	}
+
+	now := time.Now()
+	if now.Before(cert.NotBefore) {
+		return fmt.Errorf("Certificate not valid before %s", cert.NotBefore)
+	}
+
+	if now.Add(whsvr.certExpiryThreshold).After(cert.NotAfter) {
+		return fmt.Errorf("Certificate expires at %s", cert.NotAfter)
+	}
+
+	return nil
+}
+
 // This function validates that request is correct and executes Validator on deserialized object
-func (whsvr *WebhookServer) validate(ar *v1beta1.AdmissionReview, response *v1beta1.AdmissionResponse) {
+func (whsvr *WebhookServer) validate(ar *admissionv1.AdmissionReview, response *admissionv1.AdmissionResponse) {
 	req := ar.Request
+	validator := whsvr.getValidator()
 
 	glog.Infof("AdmissionReview for Kind=%v, Name=%v UID=%v Operation=%v UserInfo=%v",
 		req.Kind, req.Name, req.UID, req.Operation, req.UserInfo)
 
-	// DELETE operation for example is currently not supported
 	switch req.Operation {
 	// Validate both CREATE and UPDATE operations, as UPDATE may bring invalid fields too
-	case "CREATE", "UPDATE":
-		// Currently, Kinds other than PodSecurityPolicy are not supported
-		switch req.Kind.Kind {
-		case "PodSecurityPolicy":
-			// Parse received object, to make sure it's correct
-			var object policyv1beta1.PodSecurityPolicy
-			if err := json.Unmarshal(req.Object.Raw, &object); err != nil {
-				glog.Errorf("Could not unmarshal raw object: %v", err)
-				response.Result.Message = err.Error()
-				return
-			}
+	// DELETE and CONNECT operations are only validated by rules explicitly scoped to them
+	case admissionv1.Create, admissionv1.Update, admissionv1.Delete, admissionv1.Connect:
+		// Only kinds which have rules defined in config file are supported
+		if !validator.HasKind(req.Kind) {
+			glog.Errorf("Kind=%v not supported", req.Kind.Kind)
+			response.Result.Message = "Kind not supported"
+			return
+		}
+
+		// Objects don't need to be decoded if there are no rules to apply, e.g. on DELETE by default
+		if !validator.HasOperation(req.Kind, string(req.Operation)) {
+			glog.Infof("UID=%s: No rules for Kind=%v and Operation=%s, accepting", req.UID, req.Kind.Kind, req.Operation)
+			break
+		}
 
-			// If object is correct, we can execute queries on it
-			if err := whsvr.validator.Validate(string(req.UID), req.Kind.Kind, object); err != nil {
-				response.Result.Message = err.Error()
+		validationRequest := &ValidationRequest{
+			UID:       string(req.UID),
+			Kind:      req.Kind,
+			Name:      req.Name,
+			Namespace: req.Namespace,
+			Operation: string(req.Operation),
+			UserInfo:  req.UserInfo,
+		}
+
+		// Parse received objects into generic structure, to make sure they're correct
+		var err error
+		switch req.Operation {
+		// On DELETE, only existing object is available, so it is validated instead
+		// API servers older than 1.15 don't send it, so there is nothing to apply rules to
+		case admissionv1.Delete:
+			if len(req.OldObject.Raw) == 0 {
+				glog.Infof("UID=%s: No old object sent for Kind=%v and Operation=%s, rules not applicable, accepting", req.UID, req.Kind.Kind, req.Operation)
+				response.Allowed = true
 				return
 			}
+			validationRequest.Object, err = decodeObject(req.OldObject)
+		// On UPDATE, existing object is also available, so changes can be validated
+		case admissionv1.Update:
+			if validationRequest.Object, err = decodeObject(req.Object); err == nil {
+				validationRequest.OldObject, err = decodeObject(req.OldObject)
+			}
 		default:
-			glog.Errorf("Kind=%v not supported", req.Kind.Kind)
-			response.Result.Message = "Kind not supported"
+			validationRequest.Object, err = decodeObject(req.Object)
+		}
+		if err != nil {
+			glog.Errorf("Could not unmarshal raw object: %v", err)
+			decodeErrors.Inc()
+			response.Result.Message = err.Error()
+			return
+		}
+
+		validationRequest.NamespaceObject = whsvr.namespaceObject(req, validationRequest.Object)
+
+		// If object is correct, we can execute queries on it
+		violations := validator.Validate(validationRequest)
+
+		// Violations of rules in warn mode are shown to the user by kubectl
+		response.Warnings = violations.Filter(enforcementWarn).Messages()
+
+		// Violations of rules in audit mode are recorded in audit log of API server
+		if audits := violations.Filter(enforcementAudit).Messages(); len(audits) > 0 {
+			response.AuditAnnotations = map[string]string{
+				auditAnnotationKey: strings.Join(audits, ", "),
+			}
+		}
+
+		if !violations.Allowed() {
+			response.Result = rejectionStatus(req, violations.Filter(enforcementDeny))
 			return
 		}
 	default:
@@ -144,9 +562,103 @@ func (whsvr *WebhookServer) validate(ar *v1beta1.AdmissionReview, response *v1be
 	response.Allowed = true
 }
 
+// Looks up namespace of validated object, so rules can be scoped using namespace labels
+// Namespaces are matched using their own labels, as they may not exist yet when created
+func (whsvr *WebhookServer) namespaceObject(req *admissionv1.AdmissionRequest, object interface{}) *corev1.Namespace {
+	if req.Kind.Group == "" && req.Kind.Kind == "Namespace" {
+		namespace := &corev1.Namespace{}
+		content, ok := object.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(content, namespace); err != nil {
+			glog.Errorf("Could not convert namespace object: %v", err)
+			return nil
+		}
+		return namespace
+	}
+
+	if req.Namespace == "" || whsvr.namespaces == nil {
+		return nil
+	}
+
+	namespace, err := whsvr.namespaces.Get(req.Namespace)
+	if err != nil {
+		glog.Errorf("Could not get namespace %s: %v", req.Namespace, err)
+		return nil
+	}
+
+	return namespace
+}
+
+// Deserializes raw object into generic structure
+func decodeObject(raw runtime.RawExtension) (map[string]interface{}, error) {
+	var object unstructured.Unstructured
+	if err := object.UnmarshalJSON(raw.Raw); err != nil {
+		return nil, err
+	}
+
+	return object.UnstructuredContent(), nil
+}
+
+// Builds status of rejected request, with one cause per violation
+// Causes contain rule name, message and offending value, also index of offending element for forEach rules
+func rejectionStatus(req *admissionv1.AdmissionRequest, violations Violations) *metav1.Status {
+	status := &metav1.Status{
+		Status:  metav1.StatusFailure,
+		Message: strings.Join(violations.Messages(), ", "),
+		Reason:  metav1.StatusReasonForbidden,
+		Code:    http.StatusForbidden,
+		Details: &metav1.StatusDetails{
+			Name:  req.Name,
+			Group: req.Kind.Group,
+			Kind:  req.Kind.Kind,
+		},
+	}
+
+	for _, violation := range violations {
+		message := fmt.Sprintf("%s: %s", violation.Rule, violation.Message)
+		// Messages of forEach rules already contain index and value of the element
+		if violation.Element == nil && violation.Value != "" {
+			message = fmt.Sprintf("%s (value '%s')", message, violation.Value)
+		}
+
+		status.Details.Causes = append(status.Details.Causes, metav1.StatusCause{
+			Type:    metav1.CauseTypeForbidden,
+			Message: message,
+			Field:   fieldPath(violation.Path),
+		})
+	}
+
+	return status
+}
+
+// Converts JSONPath query consisting of single expression, e.g. {.metadata.labels.foo}, into field path,
+// e.g. metadata.labels.foo. Empty string is returned for more complex queries, which don't point to single field
+// JSON pointers of schema violations, e.g. /spec/replicas, are already field paths, so they are returned as is
+func fieldPath(path string) string {
+	if strings.HasPrefix(path, "/") {
+		return path
+	}
+
+	if !strings.HasPrefix(path, "{") || !strings.HasSuffix(path, "}") {
+		return ""
+	}
+
+	path = path[1 : len(path)-1]
+	if strings.ContainsAny(path, "{} ") {
+		return ""
+	}
+
+	return strings.TrimPrefix(path, ".")
+}
+
 // Serve method for webhook server
 // Checks if request is correct, deserializes it and passes to validate function
 func (whsvr *WebhookServer) serve(w http.ResponseWriter, r *http.Request) {
+	timer := prometheus.NewTimer(requestDuration)
+	defer timer.ObserveDuration()
+
 	// Read request body
 	var body []byte
 
@@ -175,25 +687,61 @@ func (whsvr *WebhookServer) serve(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Try to deserialize request, both admission.k8s.io/v1 and admission.k8s.io/v1beta1 versions are accepted
+	obj, gvk, err := deserializer.Decode(body, nil, nil)
+	if err != nil {
+		glog.Errorf("Can't decode request body: %v", err)
+		decodeErrors.Inc()
+		http.Error(w, fmt.Sprintf("Could not decode request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// Requests are always validated as admission.k8s.io/v1, so convert older version if needed
+	var ar *admissionv1.AdmissionReview
+	switch review := obj.(type) {
+	case *admissionv1.AdmissionReview:
+		ar = review
+	case *admissionv1beta1.AdmissionReview:
+		ar = admissionReviewFromV1beta1(review)
+	default:
+		glog.Errorf("Unsupported request type: %v", gvk)
+		http.Error(w, fmt.Sprintf("Unsupported request type %v. Expected AdmissionReview", gvk), http.StatusBadRequest)
+		return
+	}
+
+	if ar.Request == nil {
+		glog.Error("Received AdmissionReview without request")
+		http.Error(w, "AdmissionReview request empty", http.StatusBadRequest)
+		return
+	}
+
 	// Store response data
-	admissionReview := v1beta1.AdmissionReview{
-		Response: &v1beta1.AdmissionResponse{
-			Result:  &metav1.Status{},
-			Allowed: false,
-		},
+	response := &admissionv1.AdmissionResponse{
+		UID:     ar.Request.UID,
+		Result:  &metav1.Status{},
+		Allowed: false,
 	}
 
-	// Store deserialized request
-	ar := v1beta1.AdmissionReview{}
+	whsvr.validate(ar, response)
+	admissionRequests.WithLabelValues(ar.Request.Kind.Kind, string(ar.Request.Operation), strconv.FormatBool(response.Allowed)).Inc()
 
-	// Try to deserialize request
-	if _, _, err := deserializer.Decode(body, nil, &ar); err != nil {
-		glog.Errorf("Can't decode request body: %v", err)
-		admissionReview.Response.Result.Message = err.Error()
-	} else {
-		admissionReview.Response.UID = ar.Request.UID
-		// If deserialisation succeeded, validate the request
-		whsvr.validate(&ar, admissionReview.Response)
+	// Response must use the same apiVersion and kind as the request
+	var admissionReview interface{}
+	typeMeta := metav1.TypeMeta{
+		APIVersion: gvk.GroupVersion().String(),
+		Kind:       gvk.Kind,
+	}
+	switch obj.(type) {
+	case *admissionv1beta1.AdmissionReview:
+		admissionReview = admissionv1beta1.AdmissionReview{
+			TypeMeta: typeMeta,
+			Response: admissionResponseToV1beta1(response),
+		}
+	default:
+		admissionReview = admissionv1.AdmissionReview{
+			TypeMeta: typeMeta,
+			Response: response,
+		}
 	}
 
 	// Encode response
@@ -201,11 +749,54 @@ func (whsvr *WebhookServer) serve(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		glog.Errorf("Can't encode response: %v", err)
 		http.Error(w, fmt.Sprintf("Could not encode response: %v", err), http.StatusInternalServerError)
+		return
 	}
 
 	// And send it
+	w.Header().Set("Content-Type", "application/json")
 	if _, err := w.Write(resp); err != nil {
 		glog.Errorf("Can't write response: %v", err)
 		http.Error(w, fmt.Sprintf("Could not write response: %v", err), http.StatusInternalServerError)
 	}
 }
+
+// admissionReviewFromV1beta1 converts admission.k8s.io/v1beta1 AdmissionReview into admission.k8s.io/v1 one
+func admissionReviewFromV1beta1(review *admissionv1beta1.AdmissionReview) *admissionv1.AdmissionReview {
+	ar := &admissionv1.AdmissionReview{
+		TypeMeta: review.TypeMeta,
+	}
+
+	if req := review.Request; req != nil {
+		ar.Request = &admissionv1.AdmissionRequest{
+			UID:                req.UID,
+			Kind:               req.Kind,
+			Resource:           req.Resource,
+			SubResource:        req.SubResource,
+			RequestKind:        req.RequestKind,
+			RequestResource:    req.RequestResource,
+			RequestSubResource: req.RequestSubResource,
+			Name:               req.Name,
+			Namespace:          req.Namespace,
+			Operation:          admissionv1.Operation(req.Operation),
+			UserInfo:           req.UserInfo,
+			Object:             req.Object,
+			OldObject:          req.OldObject,
+			DryRun:             req.DryRun,
+			Options:            req.Options,
+		}
+	}
+
+	return ar
+}
+
+// admissionResponseToV1beta1 converts admission.k8s.io/v1 AdmissionResponse into admission.k8s.io/v1beta1 one
+// Patch fields are not converted, as validating webhook never modifies objects
+func admissionResponseToV1beta1(response *admissionv1.AdmissionResponse) *admissionv1beta1.AdmissionResponse {
+	return &admissionv1beta1.AdmissionResponse{
+		UID:              response.UID,
+		Allowed:          response.Allowed,
+		Result:           response.Result,
+		AuditAnnotations: response.AuditAnnotations,
+		Warnings:         response.Warnings,
+	}
+}
@@ -1,24 +1,37 @@
 package main
 
 import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
-	"k8s.io/api/admission/v1beta1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 func TestValidateUnsupportedOperation(t *testing.T) {
 	var whsvr WebhookServer
 
-	admissionReview := v1beta1.AdmissionReview{
-		Response: &v1beta1.AdmissionResponse{
+	admissionReview := admissionv1.AdmissionReview{
+		Response: &admissionv1.AdmissionResponse{
 			Result:  &metav1.Status{},
 			Allowed: false,
 		},
 	}
 
-	ar := v1beta1.AdmissionReview{
-		Request: &v1beta1.AdmissionRequest{
+	ar := admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
 			Operation: "NONEXISTENT",
 		},
 	}
@@ -31,17 +44,17 @@ func TestValidateUnsupportedOperation(t *testing.T) {
 }
 
 func TestValidateCreateOperation(t *testing.T) {
-	var whsvr WebhookServer
+	whsvr := WebhookServer{validator: NewValidator()}
 
-	admissionReview := v1beta1.AdmissionReview{
-		Response: &v1beta1.AdmissionResponse{
+	admissionReview := admissionv1.AdmissionReview{
+		Response: &admissionv1.AdmissionResponse{
 			Result:  &metav1.Status{},
 			Allowed: false,
 		},
 	}
 
-	ar := v1beta1.AdmissionReview{
-		Request: &v1beta1.AdmissionRequest{
+	ar := admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
 			Operation: "CREATE",
 			Kind: metav1.GroupVersionKind{
 				Kind: "NONEXISTENT",
@@ -57,17 +70,17 @@ func TestValidateCreateOperation(t *testing.T) {
 }
 
 func TestValidateUpdateOperation(t *testing.T) {
-	var whsvr WebhookServer
+	whsvr := WebhookServer{validator: NewValidator()}
 
-	admissionReview := v1beta1.AdmissionReview{
-		Response: &v1beta1.AdmissionResponse{
+	admissionReview := admissionv1.AdmissionReview{
+		Response: &admissionv1.AdmissionResponse{
 			Result:  &metav1.Status{},
 			Allowed: false,
 		},
 	}
 
-	ar := v1beta1.AdmissionReview{
-		Request: &v1beta1.AdmissionRequest{
+	ar := admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
 			Operation: "UPDATE",
 			Kind: metav1.GroupVersionKind{
 				Kind: "NONEXISTENT",
@@ -83,17 +96,17 @@ func TestValidateUpdateOperation(t *testing.T) {
 }
 
 func TestValidateUnsupportedKind(t *testing.T) {
-	var whsvr WebhookServer
+	whsvr := WebhookServer{validator: NewValidator()}
 
-	admissionReview := v1beta1.AdmissionReview{
-		Response: &v1beta1.AdmissionResponse{
+	admissionReview := admissionv1.AdmissionReview{
+		Response: &admissionv1.AdmissionResponse{
 			Result:  &metav1.Status{},
 			Allowed: false,
 		},
 	}
 
-	ar := v1beta1.AdmissionReview{
-		Request: &v1beta1.AdmissionRequest{
+	ar := admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
 			Operation: "CREATE",
 			Kind: metav1.GroupVersionKind{
 				Kind: "NONEXISTENT",
@@ -109,17 +122,26 @@ func TestValidateUnsupportedKind(t *testing.T) {
 }
 
 func TestValidatePodSecurityPolicy(t *testing.T) {
-	var whsvr WebhookServer
+	whsvr := WebhookServer{validator: NewValidator()}
 
-	admissionReview := v1beta1.AdmissionReview{
-		Response: &v1beta1.AdmissionResponse{
+	rule := ConfigRule{
+		Name:     "TestValidatePodSecurityPolicy",
+		Jsonpath: "{.metadata.name}",
+		Regexp:   "^$",
+	}
+	if err := whsvr.validator.AddRule(metav1.GroupVersionKind{Kind: "PodSecurityPolicy"}, nil, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule: %s", err)
+	}
+
+	admissionReview := admissionv1.AdmissionReview{
+		Response: &admissionv1.AdmissionResponse{
 			Result:  &metav1.Status{},
 			Allowed: false,
 		},
 	}
 
-	ar := v1beta1.AdmissionReview{
-		Request: &v1beta1.AdmissionRequest{
+	ar := admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
 			Operation: "CREATE",
 			Kind: metav1.GroupVersionKind{
 				Kind: "PodSecurityPolicy",
@@ -133,3 +155,1706 @@ func TestValidatePodSecurityPolicy(t *testing.T) {
 		t.Errorf("Valid kind PodSecurityPolicy rejected")
 	}
 }
+
+func TestValidateConfiguredKindReject(t *testing.T) {
+	whsvr := WebhookServer{validator: NewValidator()}
+
+	rule := ConfigRule{
+		Name:     "TestValidateConfiguredKindReject",
+		Jsonpath: "{.spec.containers[*].image}",
+		Regexp:   ":latest",
+		Message:  "Images with latest tag are not allowed",
+	}
+	if err := whsvr.validator.AddRule(metav1.GroupVersionKind{Kind: "Pod"}, nil, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule: %s", err)
+	}
+
+	admissionReview := admissionv1.AdmissionReview{
+		Response: &admissionv1.AdmissionResponse{
+			Result:  &metav1.Status{},
+			Allowed: false,
+		},
+	}
+
+	ar := admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
+			Operation: "CREATE",
+			Kind: metav1.GroupVersionKind{
+				Kind: "Pod",
+			},
+			Object: runtime.RawExtension{
+				Raw: []byte(`{"apiVersion":"v1","kind":"Pod","spec":{"containers":[{"image":"nginx:latest"}]}}`),
+			},
+		},
+	}
+
+	whsvr.validate(&ar, admissionReview.Response)
+
+	if admissionReview.Response.Result.Message != "Images with latest tag are not allowed" || admissionReview.Response.Allowed {
+		t.Errorf("Pod with latest image not rejected, got: '%s'", admissionReview.Response.Result.Message)
+	}
+}
+
+func TestValidateConfiguredKindAccept(t *testing.T) {
+	whsvr := WebhookServer{validator: NewValidator()}
+
+	rule := ConfigRule{
+		Name:     "TestValidateConfiguredKindAccept",
+		Jsonpath: "{.spec.containers[*].image}",
+		Regexp:   ":latest",
+		Message:  "Images with latest tag are not allowed",
+	}
+	if err := whsvr.validator.AddRule(metav1.GroupVersionKind{Kind: "Pod"}, nil, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule: %s", err)
+	}
+
+	admissionReview := admissionv1.AdmissionReview{
+		Response: &admissionv1.AdmissionResponse{
+			Result:  &metav1.Status{},
+			Allowed: false,
+		},
+	}
+
+	ar := admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
+			Operation: "CREATE",
+			Kind: metav1.GroupVersionKind{
+				Kind: "Pod",
+			},
+			Object: runtime.RawExtension{
+				Raw: []byte(`{"apiVersion":"v1","kind":"Pod","spec":{"containers":[{"image":"nginx:1.17"}]}}`),
+			},
+		},
+	}
+
+	whsvr.validate(&ar, admissionReview.Response)
+
+	if !admissionReview.Response.Allowed {
+		t.Errorf("Valid Pod rejected: %s", admissionReview.Response.Result.Message)
+	}
+}
+
+func TestKindGroupVersionKind(t *testing.T) {
+	tests := []struct {
+		kind     Kind
+		expected metav1.GroupVersionKind
+	}{
+		{Kind{Name: "Foo"}, metav1.GroupVersionKind{Group: "*", Version: "*", Kind: "Foo"}},
+		{Kind{Name: "Foo", Group: "apps", Version: "v1"}, metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Foo"}},
+		{Kind{Name: "Foo", Group: "core", Version: "v1"}, metav1.GroupVersionKind{Group: "", Version: "v1", Kind: "Foo"}},
+	}
+
+	for _, test := range tests {
+		if gvk := test.kind.GroupVersionKind(); gvk != test.expected {
+			t.Errorf("Expected GroupVersionKind '%s', got '%s'", test.expected, gvk)
+		}
+	}
+}
+
+func TestServeAdmissionReviewV1(t *testing.T) {
+	whsvr := WebhookServer{validator: NewValidator()}
+
+	rule := ConfigRule{
+		Name:     "TestServeAdmissionReviewV1",
+		Jsonpath: "{.metadata.name}",
+		Regexp:   "^$",
+	}
+	if err := whsvr.validator.AddRule(metav1.GroupVersionKind{Kind: "Pod"}, nil, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule: %s", err)
+	}
+
+	body := `{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview","request":{"uid":"foo","kind":{"kind":"Pod"},"operation":"CREATE","object":{"apiVersion":"v1","kind":"Pod","metadata":{"name":"bar"}}}}`
+	r := httptest.NewRequest("POST", "/validate", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	whsvr.serve(w, r)
+
+	var review admissionv1.AdmissionReview
+	if err := json.Unmarshal(w.Body.Bytes(), &review); err != nil {
+		t.Fatalf("Deserializing response should not fail: %s", err)
+	}
+
+	if review.APIVersion != "admission.k8s.io/v1" || review.Kind != "AdmissionReview" {
+		t.Errorf("Response should be admission.k8s.io/v1 AdmissionReview, got: %s %s", review.APIVersion, review.Kind)
+	}
+
+	if review.Response == nil || review.Response.UID != "foo" || !review.Response.Allowed {
+		t.Errorf("Valid object should be allowed and response should contain request UID, got: %+v", review.Response)
+	}
+}
+
+func TestServeAdmissionReviewV1beta1(t *testing.T) {
+	whsvr := WebhookServer{validator: NewValidator()}
+
+	rule := ConfigRule{
+		Name:     "TestServeAdmissionReviewV1beta1",
+		Jsonpath: "{.metadata.name}",
+		Regexp:   "^$",
+		Message:  "Name required",
+	}
+	if err := whsvr.validator.AddRule(metav1.GroupVersionKind{Kind: "Pod"}, nil, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule: %s", err)
+	}
+
+	body := `{"apiVersion":"admission.k8s.io/v1beta1","kind":"AdmissionReview","request":{"uid":"foo","kind":{"kind":"Pod"},"operation":"CREATE","object":{"apiVersion":"v1","kind":"Pod","metadata":{}}}}`
+	r := httptest.NewRequest("POST", "/validate", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	whsvr.serve(w, r)
+
+	var review admissionv1beta1.AdmissionReview
+	if err := json.Unmarshal(w.Body.Bytes(), &review); err != nil {
+		t.Fatalf("Deserializing response should not fail: %s", err)
+	}
+
+	if review.APIVersion != "admission.k8s.io/v1beta1" || review.Kind != "AdmissionReview" {
+		t.Errorf("Response should be admission.k8s.io/v1beta1 AdmissionReview, got: %s %s", review.APIVersion, review.Kind)
+	}
+
+	if review.Response == nil || review.Response.UID != "foo" || review.Response.Allowed || review.Response.Result.Message != "Name required" {
+		t.Errorf("Invalid object should be rejected and response should contain request UID, got: %+v", review.Response)
+	}
+}
+
+func TestServeUnsupportedVersion(t *testing.T) {
+	whsvr := WebhookServer{validator: NewValidator()}
+
+	body := `{"apiVersion":"admission.k8s.io/v2","kind":"AdmissionReview","request":{"uid":"foo"}}`
+	r := httptest.NewRequest("POST", "/validate", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	whsvr.serve(w, r)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Unsupported AdmissionReview version should be rejected, got status code: %d", w.Code)
+	}
+}
+
+func TestReadConfig(t *testing.T) {
+	whsvr := WebhookServer{validator: NewValidator()}
+
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	config := `
+kinds:
+  - name: Pod
+    rules:
+      - name: foo
+        jsonpath: "{.metadata.name}"
+`
+	if err := ioutil.WriteFile(file, []byte(config), 0644); err != nil {
+		t.Fatalf("Writing config file should not fail: %s", err)
+	}
+
+	if err := whsvr.readConfig(file, true); err != nil {
+		t.Errorf("Reading valid config file should not fail: %s", err)
+	}
+
+	if count := whsvr.getValidator().RuleCount(); count != 1 {
+		t.Errorf("Expected 1 rule to be loaded, got: %d", count)
+	}
+}
+
+func TestReadConfigKeepRulesOnError(t *testing.T) {
+	whsvr := WebhookServer{validator: NewValidator()}
+
+	rule := ConfigRule{
+		Name:     "TestReadConfigKeepRulesOnError",
+		Jsonpath: "{.metadata.name}",
+	}
+	if err := whsvr.validator.AddRule(metav1.GroupVersionKind{Kind: "Pod"}, nil, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule: %s", err)
+	}
+
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	if err := ioutil.WriteFile(file, []byte("kinds: ]"), 0644); err != nil {
+		t.Fatalf("Writing config file should not fail: %s", err)
+	}
+
+	if err := whsvr.readConfig(file, true); err == nil {
+		t.Errorf("Reading malformed config file should fail")
+	}
+
+	if count := whsvr.getValidator().RuleCount(); count != 1 {
+		t.Errorf("Previous rules should be kept when config file is invalid, got %d rules", count)
+	}
+}
+
+func TestReadConfigStrictInvalidRules(t *testing.T) {
+	whsvr := WebhookServer{validator: NewValidator()}
+
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	config := `
+kinds:
+  - name: Pod
+    rules:
+      - name: foo
+        jsonpath: "{.metadata.name}"
+        regexp: "["
+      - name: bar
+        jsonpath: "{"
+`
+	if err := ioutil.WriteFile(file, []byte(config), 0644); err != nil {
+		t.Fatalf("Writing config file should not fail: %s", err)
+	}
+
+	err := whsvr.readConfig(file, true)
+	if err == nil {
+		t.Fatalf("Reading config file with invalid rules in strict mode should fail")
+	}
+
+	if !strings.Contains(err.Error(), "rule 'foo'") || !strings.Contains(err.Error(), "rule 'bar'") {
+		t.Errorf("Error should list all invalid rules, got: %s", err)
+	}
+
+	if err := whsvr.readConfig(file, false); err != nil {
+		t.Errorf("Reading config file with invalid rules in non-strict mode should not fail: %s", err)
+	}
+}
+
+func TestReadConfigStrictUnknownKey(t *testing.T) {
+	whsvr := WebhookServer{validator: NewValidator()}
+
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	config := `
+kinds:
+  - name: Pod
+    rules:
+      - name: foo
+        jsonpath: "{.metadata.name}"
+        regex: "foo"
+`
+	if err := ioutil.WriteFile(file, []byte(config), 0644); err != nil {
+		t.Fatalf("Writing config file should not fail: %s", err)
+	}
+
+	if err := whsvr.readConfig(file, true); err == nil {
+		t.Errorf("Reading config file with unknown keys in strict mode should fail")
+	}
+
+	if err := whsvr.readConfig(file, false); err != nil {
+		t.Errorf("Reading config file with unknown keys in non-strict mode should not fail: %s", err)
+	}
+}
+
+func TestReadConfigStrictMissingFile(t *testing.T) {
+	whsvr := WebhookServer{validator: NewValidator()}
+
+	if err := whsvr.readConfig(filepath.Join(t.TempDir(), "config.yaml"), true); err == nil {
+		t.Errorf("Reading missing config file should fail")
+	}
+}
+
+func TestServeAdmissionRequestsMetric(t *testing.T) {
+	whsvr := WebhookServer{validator: NewValidator()}
+
+	counter := admissionRequests.WithLabelValues("Secret", "CREATE", "false")
+	before := testutil.ToFloat64(counter)
+
+	body := `{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview","request":{"uid":"foo","kind":{"kind":"Secret"},"operation":"CREATE"}}`
+	r := httptest.NewRequest("POST", "/validate", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	whsvr.serve(w, r)
+
+	if after := testutil.ToFloat64(counter); after != before+1 {
+		t.Errorf("Admission request should be counted. Expected %v, got %v", before+1, after)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	whsvr := WebhookServer{validator: NewValidator()}
+
+	w := httptest.NewRecorder()
+	whsvr.healthz(w, httptest.NewRequest("GET", "/healthz", nil))
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Liveness check should always succeed, got status code: %d", w.Code)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeKeyPair(t, certFile, keyFile, "foo")
+
+	certificates, err := NewCertificateReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("Creating reloader should not fail: %s", err)
+	}
+
+	whsvr := NewWebhookServer(0, certificates)
+	whsvr.minRules = 1
+
+	w := httptest.NewRecorder()
+	whsvr.readyz(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Server without config loaded should not be ready, got status code: %d", w.Code)
+	}
+
+	file := filepath.Join(dir, "config.yaml")
+	config := `
+kinds:
+  - name: Pod
+    rules:
+      - name: foo
+        jsonpath: "{.metadata.name}"
+`
+	if err := ioutil.WriteFile(file, []byte(config), 0644); err != nil {
+		t.Fatalf("Writing config file should not fail: %s", err)
+	}
+	if err := whsvr.readConfig(file, true); err != nil {
+		t.Fatalf("Reading valid config file should not fail: %s", err)
+	}
+
+	w = httptest.NewRecorder()
+	whsvr.readyz(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Server with config and valid certificate should be ready, got: %s", w.Body)
+	}
+
+	whsvr.minRules = 2
+	w = httptest.NewRecorder()
+	whsvr.readyz(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Server with not enough rules loaded should not be ready, got status code: %d", w.Code)
+	}
+
+	// Generated certificate expires in one hour
+	whsvr.minRules = 1
+	whsvr.certExpiryThreshold = 2 * time.Hour
+	w = httptest.NewRecorder()
+	whsvr.readyz(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Server with certificate close to expiry should not be ready, got status code: %d", w.Code)
+	}
+
+	whsvr.certExpiryThreshold = 0
+	whsvr.namespaces = NewNamespaceCache(fake.NewClientset(), 0)
+	w = httptest.NewRecorder()
+	whsvr.readyz(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Server without rules using namespace selector should not wait for namespace cache, got: %s", w.Body)
+	}
+
+	whsvr.mutex.Lock()
+	if err := whsvr.validator.AddRule(metav1.GroupVersionKind{Kind: "Pod"}, nil, ConfigRule{
+		Name:              "bar",
+		Jsonpath:          "{.metadata.name}",
+		NamespaceSelector: &LabelSelector{MatchLabels: map[string]string{"environment": "production"}},
+	}); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule: %s", err)
+	}
+	whsvr.mutex.Unlock()
+	w = httptest.NewRecorder()
+	whsvr.readyz(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Server with namespace cache not synced should not be ready, got status code: %d", w.Code)
+	}
+}
+
+func TestReadConfigStartsNamespaceCache(t *testing.T) {
+	whsvr := WebhookServer{validator: NewValidator(), namespaces: NewNamespaceCache(fake.NewClientset(), 0)}
+	defer whsvr.namespaces.Close()
+
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	config := `kinds:
+- name: Pod
+  rules:
+  - name: host-network
+    jsonpath: "{.spec.hostNetwork}"
+`
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatalf("Writing config file shouldn't fail: %s", err)
+	}
+	if err := whsvr.readConfig(configFile, true); err != nil {
+		t.Fatalf("Reading valid config file should not fail: %s", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if whsvr.namespaces.HasSynced() {
+		t.Errorf("Namespace cache should not be started without rules using namespace selector")
+	}
+
+	config += `    namespaceSelector:
+      matchLabels:
+        environment: production
+`
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatalf("Writing config file shouldn't fail: %s", err)
+	}
+	if err := whsvr.readConfig(configFile, true); err != nil {
+		t.Fatalf("Reading valid config file should not fail: %s", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !whsvr.namespaces.HasSynced() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Namespace cache should be started once rules using namespace selector are loaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestValidateAuditAnnotation(t *testing.T) {
+	whsvr := WebhookServer{validator: NewValidator()}
+
+	rule := ConfigRule{
+		Name:        "TestValidateAuditAnnotation",
+		Jsonpath:    "{.spec.containers[*].image}",
+		Regexp:      ":latest",
+		Enforcement: "audit",
+		Message:     "Images with latest tag are not allowed",
+	}
+	if err := whsvr.validator.AddRule(metav1.GroupVersionKind{Kind: "Pod"}, nil, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule: %s", err)
+	}
+
+	admissionReview := admissionv1.AdmissionReview{
+		Response: &admissionv1.AdmissionResponse{
+			Result:  &metav1.Status{},
+			Allowed: false,
+		},
+	}
+
+	ar := admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
+			Operation: "CREATE",
+			Kind: metav1.GroupVersionKind{
+				Kind: "Pod",
+			},
+			Object: runtime.RawExtension{
+				Raw: []byte(`{"apiVersion":"v1","kind":"Pod","spec":{"containers":[{"image":"nginx:latest"}]}}`),
+			},
+		},
+	}
+
+	whsvr.validate(&ar, admissionReview.Response)
+
+	if !admissionReview.Response.Allowed {
+		t.Errorf("Object violating rule in audit mode should be allowed: %s", admissionReview.Response.Result.Message)
+	}
+
+	if annotation := admissionReview.Response.AuditAnnotations[auditAnnotationKey]; annotation != "Images with latest tag are not allowed" {
+		t.Errorf("Violation should be recorded in audit annotation, got: '%s'", annotation)
+	}
+}
+
+func TestValidateWarnings(t *testing.T) {
+	whsvr := WebhookServer{validator: NewValidator()}
+
+	rule := ConfigRule{
+		Name:        "TestValidateWarnings",
+		Jsonpath:    "{.spec.containers[*].image}",
+		Regexp:      ":latest",
+		Enforcement: "warn",
+		Message:     "Images with latest tag are discouraged",
+	}
+	if err := whsvr.validator.AddRule(metav1.GroupVersionKind{Kind: "Pod"}, nil, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule: %s", err)
+	}
+
+	admissionReview := admissionv1.AdmissionReview{
+		Response: &admissionv1.AdmissionResponse{
+			Result:  &metav1.Status{},
+			Allowed: false,
+		},
+	}
+
+	ar := admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
+			Operation: "CREATE",
+			Kind: metav1.GroupVersionKind{
+				Kind: "Pod",
+			},
+			Object: runtime.RawExtension{
+				Raw: []byte(`{"apiVersion":"v1","kind":"Pod","spec":{"containers":[{"image":"nginx:latest"}]}}`),
+			},
+		},
+	}
+
+	whsvr.validate(&ar, admissionReview.Response)
+
+	if !admissionReview.Response.Allowed {
+		t.Errorf("Object violating rule in warn mode should be allowed: %s", admissionReview.Response.Result.Message)
+	}
+
+	if warnings := admissionReview.Response.Warnings; len(warnings) != 1 || warnings[0] != "Images with latest tag are discouraged" {
+		t.Errorf("Violation should be returned as warning, got: %v", warnings)
+	}
+}
+
+func TestValidateRejectionStatus(t *testing.T) {
+	whsvr := WebhookServer{validator: NewValidator()}
+
+	rule1 := ConfigRule{
+		Name:     "TestValidateRejectionStatus1",
+		Jsonpath: "{.metadata.labels.foo}",
+		Regexp:   "^$",
+		Message:  "Label foo missing",
+	}
+	rule2 := ConfigRule{
+		Name:     "TestValidateRejectionStatus2",
+		Jsonpath: "{.metadata.labels.bar}",
+		Regexp:   "^baz$",
+		Message:  "Label bar can't be baz",
+	}
+	for _, rule := range []ConfigRule{rule1, rule2} {
+		if err := whsvr.validator.AddRule(metav1.GroupVersionKind{Kind: "Pod"}, nil, rule); err != nil {
+			t.Errorf("Validator shouldn't fail adding rule: %s", err)
+		}
+	}
+
+	admissionReview := admissionv1.AdmissionReview{
+		Response: &admissionv1.AdmissionResponse{
+			Result:  &metav1.Status{},
+			Allowed: false,
+		},
+	}
+
+	ar := admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
+			Operation: "CREATE",
+			Name:      "foo",
+			Kind: metav1.GroupVersionKind{
+				Kind: "Pod",
+			},
+			Object: runtime.RawExtension{
+				Raw: []byte(`{"apiVersion":"v1","kind":"Pod","metadata":{"name":"foo","labels":{"bar":"baz"}}}`),
+			},
+		},
+	}
+
+	whsvr.validate(&ar, admissionReview.Response)
+
+	result := admissionReview.Response.Result
+	if admissionReview.Response.Allowed || result.Code != http.StatusForbidden || result.Reason != metav1.StatusReasonForbidden {
+		t.Errorf("Invalid object should be rejected with forbidden status, got: %+v", result)
+	}
+
+	if result.Message != "Label foo missing, Label bar can't be baz" {
+		t.Errorf("Expected joined messages, got: '%s'", result.Message)
+	}
+
+	if result.Details == nil || len(result.Details.Causes) != 2 {
+		t.Fatalf("Each violation should be reported as separate cause, got: %+v", result.Details)
+	}
+
+	if cause := result.Details.Causes[0]; cause.Type != metav1.CauseTypeForbidden || cause.Field != "metadata.labels.foo" || cause.Message != "TestValidateRejectionStatus1: Label foo missing" {
+		t.Errorf("Cause should contain rule name, message and field path, got: %+v", cause)
+	}
+
+	if cause := result.Details.Causes[1]; cause.Type != metav1.CauseTypeForbidden || cause.Field != "metadata.labels.bar" || cause.Message != "TestValidateRejectionStatus2: Label bar can't be baz (value 'baz')" {
+		t.Errorf("Cause should contain rule name, message, offending value and field path, got: %+v", cause)
+	}
+}
+
+func TestValidateRejectionStatusForEach(t *testing.T) {
+	whsvr := WebhookServer{validator: NewValidator()}
+
+	rule := ConfigRule{
+		Name:     "TestValidateRejectionStatusForEach",
+		Jsonpath: "{.spec.containers[*].image}",
+		Regexp:   "^registry\\.corp/",
+		Match:    "required",
+		ForEach:  true,
+		Message:  "Image must come from registry.corp",
+	}
+	if err := whsvr.validator.AddRule(metav1.GroupVersionKind{Kind: "Pod"}, nil, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule: %s", err)
+	}
+
+	admissionReview := admissionv1.AdmissionReview{
+		Response: &admissionv1.AdmissionResponse{
+			Result:  &metav1.Status{},
+			Allowed: false,
+		},
+	}
+
+	ar := admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
+			Operation: "CREATE",
+			Name:      "foo",
+			Kind: metav1.GroupVersionKind{
+				Kind: "Pod",
+			},
+			Object: runtime.RawExtension{
+				Raw: []byte(`{"apiVersion":"v1","kind":"Pod","metadata":{"name":"foo"},"spec":{"containers":[{"image":"registry.corp/foo"},{"image":"docker.io/bar"},{"image":"quay.io/baz"}]}}`),
+			},
+		},
+	}
+
+	whsvr.validate(&ar, admissionReview.Response)
+
+	result := admissionReview.Response.Result
+	if admissionReview.Response.Allowed || result.Details == nil || len(result.Details.Causes) != 2 {
+		t.Fatalf("Each invalid element should be reported as separate cause, got: %+v", result)
+	}
+
+	expected := "Image must come from registry.corp (element 1, value 'docker.io/bar'), Image must come from registry.corp (element 2, value 'quay.io/baz')"
+	if result.Message != expected {
+		t.Errorf("Status message should contain index and value of each invalid element. Expected: '%s', got: '%s'", expected, result.Message)
+	}
+
+	expected = "TestValidateRejectionStatusForEach: Image must come from registry.corp (element 2, value 'quay.io/baz')"
+	if cause := result.Details.Causes[1]; cause.Message != expected {
+		t.Errorf("Cause should contain index and value of invalid element once. Expected: '%s', got: '%s'", expected, cause.Message)
+	}
+}
+
+func TestFieldPath(t *testing.T) {
+	paths := map[string]string{
+		"{.metadata.labels.foo}":             "metadata.labels.foo",
+		"{.spec.containers[*].image}":        "spec.containers[*].image",
+		"{.metadata.name}{.metadata.labels}": "",
+		"{range .items[*]}{.name}{end}":      "",
+		"{}":                                 "",
+	}
+	for path, expected := range paths {
+		if field := fieldPath(path); field != expected {
+			t.Errorf("Expected field path '%s' for query '%s', got: '%s'", expected, path, field)
+		}
+	}
+}
+
+func TestValidateUpdateImmutable(t *testing.T) {
+	whsvr := WebhookServer{validator: NewValidator()}
+
+	rule := ConfigRule{
+		Name:     "TestValidateUpdateImmutable",
+		Type:     "immutable",
+		Jsonpath: "{.spec.storageClassName}",
+		Message:  "storageClassName is immutable",
+	}
+	if err := whsvr.validator.AddRule(metav1.GroupVersionKind{Kind: "PersistentVolumeClaim"}, nil, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule: %s", err)
+	}
+
+	admissionReview := admissionv1.AdmissionReview{
+		Response: &admissionv1.AdmissionResponse{
+			Result:  &metav1.Status{},
+			Allowed: false,
+		},
+	}
+
+	ar := admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
+			Operation: "UPDATE",
+			Kind: metav1.GroupVersionKind{
+				Kind: "PersistentVolumeClaim",
+			},
+			Object: runtime.RawExtension{
+				Raw: []byte(`{"apiVersion":"v1","kind":"PersistentVolumeClaim","spec":{"storageClassName":"fast"}}`),
+			},
+			OldObject: runtime.RawExtension{
+				Raw: []byte(`{"apiVersion":"v1","kind":"PersistentVolumeClaim","spec":{"storageClassName":"slow"}}`),
+			},
+		},
+	}
+
+	whsvr.validate(&ar, admissionReview.Response)
+
+	if admissionReview.Response.Allowed || admissionReview.Response.Result.Message != "storageClassName is immutable" {
+		t.Errorf("Changing immutable field should be rejected, got: '%s'", admissionReview.Response.Result.Message)
+	}
+}
+
+func TestValidateDeleteProtected(t *testing.T) {
+	whsvr := WebhookServer{validator: NewValidator()}
+
+	rule := ConfigRule{
+		Name:     "TestValidateDeleteProtected",
+		Jsonpath: "{.metadata.labels.protected}",
+		Regexp:   "^true$",
+		Message:  "Namespace {{.Name}} is protected",
+	}
+	if err := whsvr.validator.AddRule(metav1.GroupVersionKind{Kind: "Namespace"}, []string{"DELETE"}, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule: %s", err)
+	}
+
+	admissionReview := admissionv1.AdmissionReview{
+		Response: &admissionv1.AdmissionResponse{
+			Result:  &metav1.Status{},
+			Allowed: false,
+		},
+	}
+
+	ar := admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
+			Operation: "DELETE",
+			Name:      "prod",
+			Kind: metav1.GroupVersionKind{
+				Kind: "Namespace",
+			},
+			OldObject: runtime.RawExtension{
+				Raw: []byte(`{"apiVersion":"v1","kind":"Namespace","metadata":{"name":"prod","labels":{"protected":"true"}}}`),
+			},
+		},
+	}
+
+	whsvr.validate(&ar, admissionReview.Response)
+
+	if admissionReview.Response.Allowed || admissionReview.Response.Result.Message != "Namespace prod is protected" {
+		t.Errorf("Deleting protected namespace should be rejected, got: '%s'", admissionReview.Response.Result.Message)
+	}
+
+	// Rule scoped to DELETE must not affect creation of the same object
+	admissionReview.Response = &admissionv1.AdmissionResponse{
+		Result:  &metav1.Status{},
+		Allowed: false,
+	}
+	ar.Request.Operation = "CREATE"
+	ar.Request.Object = ar.Request.OldObject
+	ar.Request.OldObject = runtime.RawExtension{}
+
+	whsvr.validate(&ar, admissionReview.Response)
+
+	if !admissionReview.Response.Allowed {
+		t.Errorf("Creating protected namespace should be accepted, got: '%s'", admissionReview.Response.Result.Message)
+	}
+}
+
+func TestValidateDeleteWithoutOldObject(t *testing.T) {
+	whsvr := WebhookServer{validator: NewValidator()}
+
+	rule := ConfigRule{
+		Name:     "TestValidateDeleteWithoutOldObject",
+		Jsonpath: "{.metadata.labels.protected}",
+		Regexp:   "^true$",
+	}
+	if err := whsvr.validator.AddRule(metav1.GroupVersionKind{Kind: "Namespace"}, []string{"DELETE"}, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule: %s", err)
+	}
+
+	admissionReview := admissionv1.AdmissionReview{
+		Response: &admissionv1.AdmissionResponse{
+			Result:  &metav1.Status{},
+			Allowed: false,
+		},
+	}
+
+	ar := admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
+			Operation: "DELETE",
+			Name:      "prod",
+			Kind: metav1.GroupVersionKind{
+				Kind: "Namespace",
+			},
+		},
+	}
+
+	whsvr.validate(&ar, admissionReview.Response)
+
+	if !admissionReview.Response.Allowed {
+		t.Errorf("DELETE without old object should be accepted, got: '%s'", admissionReview.Response.Result.Message)
+	}
+}
+
+func TestValidateDeleteDefaultOperations(t *testing.T) {
+	whsvr := WebhookServer{validator: NewValidator()}
+
+	rule := ConfigRule{
+		Name:     "TestValidateDeleteDefaultOperations",
+		Jsonpath: "{.metadata.labels.protected}",
+		Regexp:   "^true$",
+	}
+	if err := whsvr.validator.AddRule(metav1.GroupVersionKind{Kind: "Namespace"}, nil, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule: %s", err)
+	}
+
+	admissionReview := admissionv1.AdmissionReview{
+		Response: &admissionv1.AdmissionResponse{
+			Result:  &metav1.Status{},
+			Allowed: false,
+		},
+	}
+
+	ar := admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
+			Operation: "DELETE",
+			Kind: metav1.GroupVersionKind{
+				Kind: "Namespace",
+			},
+			OldObject: runtime.RawExtension{
+				Raw: []byte(`{"apiVersion":"v1","kind":"Namespace","metadata":{"name":"prod","labels":{"protected":"true"}}}`),
+			},
+		},
+	}
+
+	whsvr.validate(&ar, admissionReview.Response)
+
+	if !admissionReview.Response.Allowed {
+		t.Errorf("Rules without operations should not be applied on DELETE, got: '%s'", admissionReview.Response.Result.Message)
+	}
+}
+
+func TestValidateConnectExec(t *testing.T) {
+	whsvr := WebhookServer{validator: NewValidator()}
+
+	rule := ConfigRule{
+		Name:     "TestValidateConnectExec",
+		Jsonpath: "{.kind}",
+		Regexp:   "^PodExecOptions$",
+		Message:  "Exec into pods in namespace {{.Namespace}} is not allowed",
+	}
+	if err := whsvr.validator.AddRule(metav1.GroupVersionKind{Kind: "PodExecOptions"}, []string{"CONNECT"}, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule: %s", err)
+	}
+
+	admissionReview := admissionv1.AdmissionReview{
+		Response: &admissionv1.AdmissionResponse{
+			Result:  &metav1.Status{},
+			Allowed: false,
+		},
+	}
+
+	ar := admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
+			Operation: "CONNECT",
+			Namespace: "production",
+			Kind: metav1.GroupVersionKind{
+				Kind: "PodExecOptions",
+			},
+			Object: runtime.RawExtension{
+				Raw: []byte(`{"apiVersion":"v1","kind":"PodExecOptions","command":["sh"],"stdin":true,"tty":true}`),
+			},
+		},
+	}
+
+	whsvr.validate(&ar, admissionReview.Response)
+
+	if admissionReview.Response.Allowed || admissionReview.Response.Result.Message != "Exec into pods in namespace production is not allowed" {
+		t.Errorf("Exec into pod should be rejected, got: '%s'", admissionReview.Response.Result.Message)
+	}
+}
+
+func TestReadConfigKindOperations(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	config := `kinds:
+- name: Namespace
+  operations: [DELETE]
+  rules:
+  - name: protected
+    jsonpath: "{.metadata.labels.protected}"
+    regexp: "^true$"
+  - name: name
+    operations: [CREATE]
+    jsonpath: "{.metadata.name}"
+    regexp: "^kube-"
+`
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatalf("Writing config file shouldn't fail: %s", err)
+	}
+
+	validator, err := loadConfig(configFile, true)
+	if err != nil {
+		t.Fatalf("Loading config shouldn't fail: %s", err)
+	}
+
+	rules := validator.rulesFor(metav1.GroupVersionKind{Kind: "Namespace"})
+	if len(rules) != 2 {
+		t.Fatalf("Expected 2 rules, got %d", len(rules))
+	}
+	if !rules[0].operations["DELETE"] || rules[0].operations["CREATE"] {
+		t.Errorf("Rule without operations should inherit operations of the kind, got: %v", rules[0].operations)
+	}
+	if !rules[1].operations["CREATE"] || rules[1].operations["DELETE"] {
+		t.Errorf("Rule operations should override operations of the kind, got: %v", rules[1].operations)
+	}
+}
+
+func TestReadConfigKindOperationsSameKind(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	config := `kinds:
+- name: Pod
+  operations: [DELETE]
+  rules:
+  - name: a
+    jsonpath: "{.metadata.labels.protected}"
+- name: Pod
+  rules:
+  - name: b
+    jsonpath: "{.spec.hostNetwork}"
+`
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatalf("Writing config file shouldn't fail: %s", err)
+	}
+
+	validator, err := loadConfig(configFile, true)
+	if err != nil {
+		t.Fatalf("Loading config shouldn't fail: %s", err)
+	}
+
+	rules := validator.rulesFor(metav1.GroupVersionKind{Kind: "Pod"})
+	if len(rules) != 2 {
+		t.Fatalf("Expected 2 rules, got %d", len(rules))
+	}
+	if !rules[1].operations["CREATE"] || !rules[1].operations["UPDATE"] || rules[1].operations["DELETE"] {
+		t.Errorf("Rule should not inherit operations of other kind entry with the same name, got: %v", rules[1].operations)
+	}
+}
+
+func TestReadConfigInvalidKindOperationsStrict(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	config := `kinds:
+- name: Pod
+  operations: [PATCH]
+  rules:
+  - name: valid
+    jsonpath: "{.spec.hostNetwork}"
+  - name: invalid
+    jsonpath: "{.spec.hostNetwork}"
+    match: sometimes
+`
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatalf("Writing config file shouldn't fail: %s", err)
+	}
+
+	_, err := loadConfig(configFile, true)
+	if err == nil {
+		t.Fatalf("Loading config with invalid operations should fail in strict mode")
+	}
+	if !strings.Contains(err.Error(), "operations for kind") || !strings.Contains(err.Error(), "rule 'invalid'") {
+		t.Errorf("Error should list invalid operations and invalid rules of the kind, got: %s", err)
+	}
+
+	validator, err := loadConfig(configFile, false)
+	if err != nil {
+		t.Fatalf("Loading config shouldn't fail in non-strict mode: %s", err)
+	}
+	if validator.HasKind(metav1.GroupVersionKind{Kind: "Pod"}) {
+		t.Errorf("Rules of kind with invalid operations should be skipped")
+	}
+}
+
+func TestValidateDeleteWithoutRules(t *testing.T) {
+	whsvr := WebhookServer{validator: NewValidator()}
+
+	rule := ConfigRule{
+		Name:     "TestValidateDeleteWithoutRules",
+		Jsonpath: "{.metadata.labels.protected}",
+		Regexp:   "^true$",
+	}
+	if err := whsvr.validator.AddRule(metav1.GroupVersionKind{Kind: "Namespace"}, nil, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule: %s", err)
+	}
+
+	admissionReview := admissionv1.AdmissionReview{
+		Response: &admissionv1.AdmissionResponse{
+			Result:  &metav1.Status{},
+			Allowed: false,
+		},
+	}
+
+	ar := admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
+			Operation: "DELETE",
+			Kind: metav1.GroupVersionKind{
+				Kind: "Namespace",
+			},
+		},
+	}
+
+	whsvr.validate(&ar, admissionReview.Response)
+
+	if !admissionReview.Response.Allowed {
+		t.Errorf("DELETE without old object should be accepted if no rules apply to DELETE, got: '%s'", admissionReview.Response.Result.Message)
+	}
+}
+
+func TestReadConfigImmutableKindOperations(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	config := `kinds:
+- name: PersistentVolumeClaim
+  operations: [CREATE, UPDATE]
+  rules:
+  - name: storage-class
+    type: immutable
+    jsonpath: "{.spec.storageClassName}"
+`
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatalf("Writing config file shouldn't fail: %s", err)
+	}
+
+	if _, err := loadConfig(configFile, true); err != nil {
+		t.Errorf("Immutable rule should not inherit operations of the kind: %s", err)
+	}
+}
+
+func TestReadConfigNamespaceSelector(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	config := `kinds:
+- name: Pod
+  rules:
+  - name: host-network
+    jsonpath: "{.spec.hostNetwork}"
+    regexp: "true"
+    excludeNamespaces: ["kube-*"]
+    namespaceSelector:
+      matchLabels:
+        environment: production
+      matchExpressions:
+      - key: team
+        operator: NotIn
+        values: [platform]
+`
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatalf("Writing config file shouldn't fail: %s", err)
+	}
+
+	validator, err := loadConfig(configFile, true)
+	if err != nil {
+		t.Fatalf("Loading config shouldn't fail: %s", err)
+	}
+
+	rule := validator.rulesFor(metav1.GroupVersionKind{Kind: "Pod"})[0]
+	if expected := "environment=production,team notin (platform)"; rule.namespaceSelector == nil || rule.namespaceSelector.String() != expected {
+		t.Errorf("Expected namespace selector '%s', got: %v", expected, rule.namespaceSelector)
+	}
+	if len(rule.excludeNamespaces) != 1 || rule.excludeNamespaces[0] != "kube-*" {
+		t.Errorf("Expected excluded namespaces to be loaded, got: %v", rule.excludeNamespaces)
+	}
+}
+
+func TestReadConfigNamespaceSelectorUnknownKey(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	config := `kinds:
+- name: Pod
+  rules:
+  - name: host-network
+    jsonpath: "{.spec.hostNetwork}"
+    namespaceSelector:
+      matchLabel:
+        environment: production
+`
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatalf("Writing config file shouldn't fail: %s", err)
+	}
+
+	if _, err := loadConfig(configFile, true); err == nil {
+		t.Errorf("Loading config with unknown namespace selector key should fail in strict mode")
+	}
+}
+
+func TestValidateNamespaceSelectorCache(t *testing.T) {
+	client := fake.NewClientset(&corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "foo",
+			Labels: map[string]string{"environment": "production"},
+		},
+	})
+	namespaces := NewNamespaceCache(client, 0)
+	defer namespaces.Close()
+	go namespaces.Run()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !namespaces.HasSynced() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Namespace cache should be synced")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	whsvr := WebhookServer{validator: NewValidator(), namespaces: namespaces}
+
+	rule := ConfigRule{
+		Name:     "TestValidateNamespaceSelectorCache",
+		Jsonpath: "{.spec.hostNetwork}",
+		Regexp:   "true",
+		Message:  "Host network is not allowed in production",
+		NamespaceSelector: &LabelSelector{
+			MatchLabels: map[string]string{"environment": "production"},
+		},
+	}
+	if err := whsvr.validator.AddRule(metav1.GroupVersionKind{Kind: "Pod"}, nil, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule: %s", err)
+	}
+
+	admissionReview := admissionv1.AdmissionReview{
+		Response: &admissionv1.AdmissionResponse{
+			Result:  &metav1.Status{},
+			Allowed: false,
+		},
+	}
+
+	ar := admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
+			Operation: "CREATE",
+			Namespace: "foo",
+			Kind: metav1.GroupVersionKind{
+				Kind: "Pod",
+			},
+			Object: runtime.RawExtension{
+				Raw: []byte(`{"apiVersion":"v1","kind":"Pod","spec":{"hostNetwork":true}}`),
+			},
+		},
+	}
+
+	whsvr.validate(&ar, admissionReview.Response)
+
+	if admissionReview.Response.Allowed || admissionReview.Response.Result.Message != "Host network is not allowed in production" {
+		t.Errorf("Object in namespace matching selector should be rejected, got: '%s'", admissionReview.Response.Result.Message)
+	}
+}
+
+func TestValidateNamespaceSelectorOwnLabels(t *testing.T) {
+	whsvr := WebhookServer{validator: NewValidator()}
+
+	rule := ConfigRule{
+		Name:     "TestValidateNamespaceSelectorOwnLabels",
+		Jsonpath: "{.metadata.labels.team}",
+		Match:    "required",
+		Message:  "Production namespaces must have team label",
+		NamespaceSelector: &LabelSelector{
+			MatchLabels: map[string]string{"environment": "production"},
+		},
+	}
+	if err := whsvr.validator.AddRule(metav1.GroupVersionKind{Kind: "Namespace"}, nil, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule: %s", err)
+	}
+
+	admissionReview := admissionv1.AdmissionReview{
+		Response: &admissionv1.AdmissionResponse{
+			Result:  &metav1.Status{},
+			Allowed: false,
+		},
+	}
+
+	ar := admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
+			Operation: "CREATE",
+			Name:      "foo",
+			Namespace: "foo",
+			Kind: metav1.GroupVersionKind{
+				Kind: "Namespace",
+			},
+			Object: runtime.RawExtension{
+				Raw: []byte(`{"apiVersion":"v1","kind":"Namespace","metadata":{"name":"foo","labels":{"environment":"production"}}}`),
+			},
+		},
+	}
+
+	whsvr.validate(&ar, admissionReview.Response)
+
+	if admissionReview.Response.Allowed || admissionReview.Response.Result.Message != "Production namespaces must have team label" {
+		t.Errorf("Namespace should be matched using its own labels, got: '%s'", admissionReview.Response.Result.Message)
+	}
+}
+
+func TestValidateNamespaceSelectorOwnLabelsUpdate(t *testing.T) {
+	whsvr := WebhookServer{validator: NewValidator()}
+
+	rule := ConfigRule{
+		Name:     "TestValidateNamespaceSelectorOwnLabelsUpdate",
+		Jsonpath: "{.metadata.labels.team}",
+		Match:    "required",
+		Message:  "Production namespaces must have team label",
+		NamespaceSelector: &LabelSelector{
+			MatchLabels: map[string]string{"environment": "production"},
+		},
+	}
+	if err := whsvr.validator.AddRule(metav1.GroupVersionKind{Kind: "Namespace"}, nil, rule); err != nil {
+		t.Errorf("Validator shouldn't fail adding rule: %s", err)
+	}
+
+	admissionReview := admissionv1.AdmissionReview{
+		Response: &admissionv1.AdmissionResponse{
+			Result:  &metav1.Status{},
+			Allowed: false,
+		},
+	}
+
+	ar := admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
+			Operation: "UPDATE",
+			Name:      "foo",
+			Namespace: "foo",
+			Kind: metav1.GroupVersionKind{
+				Kind: "Namespace",
+			},
+			Object: runtime.RawExtension{
+				Raw: []byte(`{"apiVersion":"v1","kind":"Namespace","metadata":{"name":"foo"}}`),
+			},
+			OldObject: runtime.RawExtension{
+				Raw: []byte(`{"apiVersion":"v1","kind":"Namespace","metadata":{"name":"foo","labels":{"environment":"production","team":"a"}}}`),
+			},
+		},
+	}
+
+	whsvr.validate(&ar, admissionReview.Response)
+
+	if admissionReview.Response.Allowed || admissionReview.Response.Result.Message != "Production namespaces must have team label" {
+		t.Errorf("Namespace should be matched using labels of existing namespace on UPDATE, got: '%s'", admissionReview.Response.Result.Message)
+	}
+
+	ar.Request.OldObject.Raw = []byte(`{"apiVersion":"v1","kind":"Namespace","metadata":{"name":"foo"}}`)
+	admissionReview.Response = &admissionv1.AdmissionResponse{Result: &metav1.Status{}}
+
+	whsvr.validate(&ar, admissionReview.Response)
+
+	if !admissionReview.Response.Allowed {
+		t.Errorf("Namespace not matching selector before and after UPDATE should be allowed, got: '%s'", admissionReview.Response.Result.Message)
+	}
+}
+
+func TestLabelSelectorAnd(t *testing.T) {
+	var empty *LabelSelector
+	if empty.And(nil) != nil {
+		t.Errorf("Combining nil selectors should return nil")
+	}
+
+	kind := &LabelSelector{MatchLabels: map[string]string{"tier": "frontend"}}
+	if empty.And(kind) != kind || kind.And(nil) != kind {
+		t.Errorf("Combining selector with nil selector should return the selector")
+	}
+
+	rule := &LabelSelector{
+		MatchLabels:      map[string]string{"tier": "backend"},
+		MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "team", Operator: metav1.LabelSelectorOpExists}},
+	}
+	selector, err := metav1.LabelSelectorAsSelector((*metav1.LabelSelector)(kind.And(rule)))
+	if err != nil {
+		t.Fatalf("Combined selector should be valid: %s", err)
+	}
+	if expected := "team,tier in (frontend),tier in (backend)"; selector.String() != expected {
+		t.Errorf("Expected selector '%s', got: '%s'", expected, selector)
+	}
+}
+
+func TestReadConfigObjectSelector(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	config := `kinds:
+- name: Pod
+  objectSelector:
+    matchLabels:
+      tier: frontend
+  rules:
+  - name: host-network
+    jsonpath: "{.spec.hostNetwork}"
+    regexp: "true"
+  - name: privileged
+    jsonpath: "{.spec.containers[*].securityContext.privileged}"
+    regexp: "true"
+    objectSelector:
+      matchExpressions:
+      - key: team
+        operator: Exists
+`
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatalf("Writing config file shouldn't fail: %s", err)
+	}
+
+	validator, err := loadConfig(configFile, true)
+	if err != nil {
+		t.Fatalf("Loading config shouldn't fail: %s", err)
+	}
+
+	rules := validator.rulesFor(metav1.GroupVersionKind{Kind: "Pod"})
+	if expected := "tier=frontend"; rules[0].objectSelector == nil || rules[0].objectSelector.String() != expected {
+		t.Errorf("Rule should inherit object selector of the kind. Expected '%s', got: %v", expected, rules[0].objectSelector)
+	}
+	if expected := "team,tier in (frontend)"; rules[1].objectSelector == nil || rules[1].objectSelector.String() != expected {
+		t.Errorf("Rule object selector should be combined with object selector of the kind. Expected '%s', got: %v", expected, rules[1].objectSelector)
+	}
+}
+
+func TestReadConfigKindExemptions(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	config := `kinds:
+- name: Pod
+  exemptGroups: ["system:masters"]
+  rules:
+  - name: host-network
+    jsonpath: "{.spec.hostNetwork}"
+    regexp: "true"
+    exemptServiceAccounts: ["ci/*"]
+`
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatalf("Writing config file shouldn't fail: %s", err)
+	}
+
+	validator, err := loadConfig(configFile, true)
+	if err != nil {
+		t.Fatalf("Loading config shouldn't fail: %s", err)
+	}
+
+	rule := validator.rulesFor(metav1.GroupVersionKind{Kind: "Pod"})[0]
+	if len(rule.exemptGroups) != 1 || rule.exemptGroups[0] != "system:masters" {
+		t.Errorf("Rule should inherit exemptions of the kind, got: %v", rule.exemptGroups)
+	}
+	if len(rule.exemptServiceAccounts) != 1 || rule.exemptServiceAccounts[0] != "ci/*" {
+		t.Errorf("Rule should keep its own exemptions, got: %v", rule.exemptServiceAccounts)
+	}
+}
+
+func TestReadConfigCEL(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	config := `kinds:
+- name: Pod
+  rules:
+  - name: host-ports
+    cel: "!has(object.spec.hostNetwork) || !object.spec.hostNetwork || object.spec.containers.all(c, has(c.ports))"
+    message: "Pods using host network must define ports"
+`
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatalf("Writing config file shouldn't fail: %s", err)
+	}
+
+	validator, err := loadConfig(configFile, true)
+	if err != nil {
+		t.Fatalf("Loading config shouldn't fail: %s", err)
+	}
+
+	if rule := validator.rulesFor(metav1.GroupVersionKind{Kind: "Pod"})[0]; rule.expression == nil {
+		t.Errorf("CEL expression should be compiled when config is loaded")
+	}
+
+	config = strings.Replace(config, "!has(object.spec.hostNetwork) || !object.spec.hostNetwork || object.spec.containers.all(c, has(c.ports))", "size(object.spec.containers)", 1)
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatalf("Writing config file shouldn't fail: %s", err)
+	}
+	if _, err := loadConfig(configFile, true); err == nil {
+		t.Errorf("Loading config with expression not returning bool should fail in strict mode")
+	}
+}
+
+func TestReadConfigCELNamespaceObject(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	config := `kinds:
+- name: Pod
+  rules:
+  - name: production
+    cel: "namespaceObject == null || !has(namespaceObject.metadata.labels.environment) || has(object.metadata.labels.team)"
+`
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatalf("Writing config file shouldn't fail: %s", err)
+	}
+
+	validator, err := loadConfig(configFile, true)
+	if err != nil {
+		t.Fatalf("Loading config shouldn't fail: %s", err)
+	}
+
+	if !validator.UsesNamespaces() {
+		t.Errorf("Validator with CEL expression referring to namespace object should use namespaces")
+	}
+}
+
+func TestReadConfigRegoFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "policies"), 0755); err != nil {
+		t.Fatalf("Creating directory shouldn't fail: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "policies", "images.rego"), []byte(regoTestPolicy), 0644); err != nil {
+		t.Fatalf("Writing policy file shouldn't fail: %s", err)
+	}
+
+	configFile := filepath.Join(dir, "config.yaml")
+	config := `kinds:
+- name: Pod
+  rules:
+  - name: images
+    regoFile: policies/images.rego
+`
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatalf("Writing config file shouldn't fail: %s", err)
+	}
+
+	whsvr := WebhookServer{validator: NewValidator()}
+	if err := whsvr.readConfig(configFile, true); err != nil {
+		t.Fatalf("Loading config shouldn't fail: %s", err)
+	}
+
+	admissionReview := admissionv1.AdmissionReview{
+		Response: &admissionv1.AdmissionResponse{
+			Result:  &metav1.Status{},
+			Allowed: false,
+		},
+	}
+
+	ar := admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
+			Operation: "CREATE",
+			Name:      "foo",
+			Kind: metav1.GroupVersionKind{
+				Version: "v1",
+				Kind:    "Pod",
+			},
+			Object: runtime.RawExtension{
+				Raw: []byte(`{"apiVersion":"v1","kind":"Pod","metadata":{"name":"foo"},"spec":{"containers":[{"name":"web","image":"docker.io/web"},{"name":"proxy","image":"docker.io/proxy"}]}}`),
+			},
+		},
+	}
+
+	whsvr.validate(&ar, admissionReview.Response)
+
+	result := admissionReview.Response.Result
+	if admissionReview.Response.Allowed || result.Details == nil || len(result.Details.Causes) != 2 {
+		t.Fatalf("Each message of deny rule should be reported as separate cause, got: %+v", result)
+	}
+	for _, cause := range result.Details.Causes {
+		if !strings.HasPrefix(cause.Message, "images: Image docker.io/") {
+			t.Errorf("Cause should contain rule name and message of deny rule, got: '%s'", cause.Message)
+		}
+	}
+}
+
+func TestReadConfigRegoFileErrors(t *testing.T) {
+	configs := map[string]string{
+		"missing file": `kinds:
+- name: Pod
+  rules:
+  - name: images
+    regoFile: missing.rego
+`,
+		"inline and file": `kinds:
+- name: Pod
+  rules:
+  - name: images
+    regoFile: images.rego
+    rego: "package foo"
+`,
+	}
+
+	for name, config := range configs {
+		dir := t.TempDir()
+		if err := ioutil.WriteFile(filepath.Join(dir, "images.rego"), []byte(regoTestPolicy), 0644); err != nil {
+			t.Fatalf("Writing policy file shouldn't fail: %s", err)
+		}
+		configFile := filepath.Join(dir, "config.yaml")
+		if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+			t.Fatalf("Writing config file shouldn't fail: %s", err)
+		}
+
+		if _, err := loadConfig(configFile, true); err == nil {
+			t.Errorf("Loading config with %s should fail in strict mode", name)
+		}
+	}
+}
+
+func TestReadConfigSchema(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	config := `kinds:
+- name: Foo
+  group: example.com
+  schema:
+    type: object
+    properties:
+      spec:
+        type: object
+        properties:
+          replicas:
+            type: integer
+            minimum: 1
+          owner:
+            type: string
+            pattern: "^team-"
+  rules:
+  - name: name
+    jsonpath: "{.metadata.name}"
+    regexp: "^bar$"
+`
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatalf("Writing config file shouldn't fail: %s", err)
+	}
+
+	whsvr := WebhookServer{validator: NewValidator()}
+	if err := whsvr.readConfig(configFile, true); err != nil {
+		t.Fatalf("Loading config shouldn't fail: %s", err)
+	}
+
+	admissionReview := admissionv1.AdmissionReview{
+		Response: &admissionv1.AdmissionResponse{
+			Result:  &metav1.Status{},
+			Allowed: false,
+		},
+	}
+
+	ar := admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
+			Operation: "CREATE",
+			Name:      "bar",
+			Kind: metav1.GroupVersionKind{
+				Group:   "example.com",
+				Version: "v1",
+				Kind:    "Foo",
+			},
+			Object: runtime.RawExtension{
+				Raw: []byte(`{"apiVersion":"example.com/v1","kind":"Foo","metadata":{"name":"bar"},"spec":{"replicas":0,"owner":"alice"}}`),
+			},
+		},
+	}
+
+	whsvr.validate(&ar, admissionReview.Response)
+
+	result := admissionReview.Response.Result
+	if admissionReview.Response.Allowed || result.Details == nil || len(result.Details.Causes) != 3 {
+		t.Fatalf("Each schema error and rule violation should be reported as separate cause, got: %+v", result)
+	}
+
+	fields := map[string]string{}
+	for _, cause := range result.Details.Causes {
+		fields[cause.Field] = cause.Message
+	}
+	for _, field := range []string{"/spec/replicas", "/spec/owner", "metadata.name"} {
+		if _, ok := fields[field]; !ok {
+			t.Errorf("Expected cause for field '%s', got: %+v", field, result.Details.Causes)
+		}
+	}
+	if message := fields["/spec/replicas"]; !strings.HasPrefix(message, "schema: at '/spec/replicas': ") {
+		t.Errorf("Cause should contain rule name and JSON pointer of invalid field, got: '%s'", message)
+	}
+
+	ar.Request.Object.Raw = []byte(`{"apiVersion":"example.com/v1","kind":"Foo","metadata":{"name":"foo"},"spec":{"replicas":1,"owner":"team-a"}}`)
+	admissionReview.Response = &admissionv1.AdmissionResponse{Result: &metav1.Status{}}
+	whsvr.validate(&ar, admissionReview.Response)
+	if !admissionReview.Response.Allowed {
+		t.Errorf("Object matching schema and rules should be allowed, got: %+v", admissionReview.Response.Result)
+	}
+}
+
+func TestReadConfigSchemaFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "schemas"), 0755); err != nil {
+		t.Fatalf("Creating directory shouldn't fail: %s", err)
+	}
+	schema := `type: object
+required: [spec]
+properties:
+  spec:
+    $ref: spec.yaml
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "schemas", "foo.yaml"), []byte(schema), 0644); err != nil {
+		t.Fatalf("Writing schema file shouldn't fail: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "schemas", "spec.yaml"), []byte(schemaTestDocument), 0644); err != nil {
+		t.Fatalf("Writing schema file shouldn't fail: %s", err)
+	}
+
+	configFile := filepath.Join(dir, "config.yaml")
+	config := `kinds:
+- name: Foo
+  schemaFile: schemas/foo.yaml
+`
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatalf("Writing config file shouldn't fail: %s", err)
+	}
+
+	validator, err := loadConfig(configFile, true)
+	if err != nil {
+		t.Fatalf("Loading config shouldn't fail: %s", err)
+	}
+
+	req := &ValidationRequest{
+		UID:       "TestReadConfigSchemaFile",
+		Kind:      metav1.GroupVersionKind{Kind: "Foo"},
+		Operation: "CREATE",
+		Object:    map[string]interface{}{"spec": map[string]interface{}{}},
+	}
+	violations := validator.Validate(req)
+	if len(violations) != 1 || violations[0].Rule != schemaRuleName || violations[0].Path != "/spec" {
+		t.Errorf("Schema referenced from schema file should be validated, got: %+v", violations)
+	}
+}
+
+func TestReadConfigSchemaErrors(t *testing.T) {
+	configs := map[string]string{
+		"missing file": `kinds:
+- name: Foo
+  schemaFile: missing.json
+  rules: []
+`,
+		"inline and file": `kinds:
+- name: Foo
+  schemaFile: foo.json
+  schema:
+    type: object
+  rules: []
+`,
+		"invalid schema": `kinds:
+- name: Foo
+  schema:
+    type: foo
+  rules: []
+`,
+		"schema rule": `kinds:
+- name: Foo
+  rules:
+  - name: schema
+    type: schema
+`,
+	}
+
+	for name, config := range configs {
+		dir := t.TempDir()
+		if err := ioutil.WriteFile(filepath.Join(dir, "foo.json"), []byte(schemaTestDocument), 0644); err != nil {
+			t.Fatalf("Writing schema file shouldn't fail: %s", err)
+		}
+		configFile := filepath.Join(dir, "config.yaml")
+		if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+			t.Fatalf("Writing config file shouldn't fail: %s", err)
+		}
+
+		if _, err := loadConfig(configFile, true); err == nil {
+			t.Errorf("Loading config with %s should fail in strict mode", name)
+		}
+	}
+}
+
+func TestReadConfigComposite(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	config := `kinds:
+- name: Pod
+  rules:
+  - name: privileged
+    allOf:
+    - jsonpath: "{.spec.containers[*].securityContext.privileged}"
+      regexp: "true"
+    - not:
+        anyOf:
+        - jsonpath: "{.metadata.namespace}"
+          regexp: "^system-"
+        - jsonpath: "{.metadata.labels.privileged}"
+          regexp: "^allowed$"
+    message: "Privileged containers are not allowed"
+`
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatalf("Writing config file shouldn't fail: %s", err)
+	}
+
+	validator, err := loadConfig(configFile, true)
+	if err != nil {
+		t.Fatalf("Loading config shouldn't fail: %s", err)
+	}
+
+	objects := map[string]bool{
+		`{"metadata":{"namespace":"prod"},"spec":{"containers":[{"securityContext":{"privileged":true}}]}}`:                                   false,
+		`{"metadata":{"namespace":"prod","labels":{"privileged":"allowed"}},"spec":{"containers":[{"securityContext":{"privileged":true}}]}}`: true,
+		`{"metadata":{"namespace":"system-dns"},"spec":{"containers":[{"securityContext":{"privileged":true}}]}}`:                             true,
+		`{"metadata":{"namespace":"prod"},"spec":{"containers":[{"name":"web"}]}}`:                                                            true,
+	}
+
+	for data, allowed := range objects {
+		var object map[string]interface{}
+		if err := json.Unmarshal([]byte(data), &object); err != nil {
+			t.Fatalf("Deserializing should not fail: %s", err)
+		}
+
+		violations := validator.Validate(&ValidationRequest{UID: "TestReadConfigComposite", Kind: metav1.GroupVersionKind{Kind: "Pod"}, Operation: "CREATE", Object: object})
+		if violations.Allowed() != allowed {
+			t.Errorf("Expected object %s to be allowed: %t, got violations: %v", data, allowed, violations.Messages())
+		}
+	}
+
+	config = strings.Replace(config, "    - not:\n", "    - jsonpath: \"{.metadata.name}\"\n      not:\n", 1)
+	if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatalf("Writing config file shouldn't fail: %s", err)
+	}
+	if _, err := loadConfig(configFile, true); err == nil {
+		t.Errorf("Loading config with condition having both jsonpath and not should fail in strict mode")
+	}
+}